@@ -34,6 +34,10 @@ func TestRun(t *testing.T) {
 			cfgFile: "../test/no-connector.tezosconnect.yaml",
 			errMsg:  "FF23051",
 		},
+		{
+			name:    "success without the signatory URL set at blockchain.signatory.url",
+			cfgFile: "../test/string-signatory.tezosconnect.yaml",
+		},
 	}
 
 	for _, tc := range testCases {
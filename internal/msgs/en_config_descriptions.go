@@ -21,8 +21,23 @@ var (
 	ConfigEventsCatchupThreshold      = ffc("config.connector.events.catchupThreshold", "How many blocks behind the chain head an event stream or listener must be on startup, to enter catchup mode", i18n.IntType)
 	ConfigEventsCheckpointBlockGap    = ffc("config.connector.events.checkpointBlockGap", "The number of blocks at the head of the chain that should be considered unstable (could be dropped from the canonical chain after a re-org). Unless events with a full set of confirmations are detected, the restart checkpoint will this many blocks behind the chain head.", i18n.IntType)
 	ConfigEventsFilterPollingInterval = ffc("config.connector.events.filterPollingInterval", "The interval between polling calls to a filter, when checking for newly arrived events", i18n.TimeDurationType)
-	ConfigTxCacheSize                 = ffc("config.connector.txCacheSize", "Maximum of transactions to hold in the transaction info cache", i18n.IntType)
+	ConfigFeesBumpPercent             = ffc("config.connector.fees.bumpPercent", "The percentage to raise the fee by when an operation that was not included is resubmitted, so that it replaces the original in the mempool", i18n.FloatType)
+	ConfigFeesMaxFee                  = ffc("config.connector.fees.maxFee", "The maximum fee in mutez that an operation can pay, when it is prepared and when its fee is raised for a resubmission (0 for no maximum)", i18n.IntType)
+	ConfigFeesMinimalFee              = ffc("config.connector.fees.minimalFee", "The minimal fee in mutez that every operation must pay, overriding the mempool configuration of the node", i18n.IntType)
+	ConfigFeesNanotezPerByte          = ffc("config.connector.fees.nanotezPerByte", "The fee in nanotez that an operation must pay per byte, overriding the mempool configuration of the node", i18n.IntType)
+	ConfigFeesNanotezPerGasUnit       = ffc("config.connector.fees.nanotezPerGasUnit", "The fee in nanotez that an operation must pay per unit of gas, overriding the mempool configuration of the node", i18n.IntType)
+	ConfigLimitsMaxGasLimit           = ffc("config.connector.limits.maxGasLimit", "The maximum gas limit of a prepared operation, summed over its contents (0 for no maximum)", i18n.IntType)
+	ConfigLimitsMaxStorageLimit       = ffc("config.connector.limits.maxStorageLimit", "The maximum storage limit in bytes of a prepared operation, summed over its contents (0 for no maximum)", i18n.IntType)
+	ConfigLimitsSafetyMargin          = ffc("config.connector.limits.safetyMargin", "The margin added to the simulated gas (and storage, if any) of each content of a prepared operation, to set its limits", i18n.IntType)
+	ConfigReceiptsMaxScanDepth        = ffc("config.connector.receipts.maxScanDepth", "The number of blocks back from the head of the chain to search for an operation that is not in the transaction cache, when getting its receipt (0 to only use the cache)", i18n.IntType)
+	ConfigSignerType                  = ffc("config.connector.signer.type", "How operations are signed: 'signatory' with the Signatory service at blockchain.signatory.url, 'keystore' with keys loaded from a local keystore, or 'jsonrpc' with a remote signer at signer.jsonrpc.url that implements the JSON-RPC signer protocol", "signatory,keystore,jsonrpc")
+	ConfigSignerKeystorePath          = ffc("config.connector.signer.keystore.path", "The keystore directory, in the format of the base directory of the Tezos client, with the keys in its secret_keys file", i18n.StringType)
+	ConfigSignerKeystorePasswordFile  = ffc("config.connector.signer.keystore.passwordFile", "A file containing the password of the encrypted keys in the keystore", i18n.StringType)
+	ConfigSignerJSONRPCURL            = ffc("config.connector.signer.jsonrpc.url", "URL of the remote signer, for the jsonrpc signer type", "string")
+	ConfigPublicKeyCacheSize          = ffc("config.connector.publicKeyCacheSize", "Maximum of public keys of accounts to hold in the cache, after looking them up from the signer to reveal the accounts", i18n.IntType)
+	ConfigTxCacheSize                 = ffc("config.connector.txCacheSize", "Maximum of operations to hold in the transaction info cache, which records the block each operation of a new block was included in, to get its receipt", i18n.IntType)
 	ConfigTezosRPC                    = ffc("config.connector.blockchain.rpc", "URL of the Tezos RPC node", "string")
 	ConfigTezosNetwork                = ffc("config.connector.blockchain.network", "Tezos network, by default - mainnet (mainnet | ghostnet | parisnet)", "string")
-	ConfigTezosSignatory              = ffc("config.connector.blockchain.signatory", "URL of the signatory service for remote tx signing", "string")
+	ConfigTezosSignatoryURL           = ffc("config.connector.blockchain.signatory.url", "URL of the signatory service for remote tx signing. Earlier versions set it as the value of blockchain.signatory, which must be moved here when upgrading", "string")
+	ConfigTezosSignatoryAuthKey       = ffc("config.connector.blockchain.signatory.authKey", "The secret key (edsk..., spsk... or p2sk...) that authenticates sign requests, for a signatory that only accepts requests from authorized keys", i18n.StringType)
 )
@@ -51,4 +51,26 @@ var (
 	MsgUnmarshalErrorFail        = ffe("FF23049", "Failed to parse error %d: %s")
 	MsgMissingRPCUrl             = ffe("FF23051", "Blockchain RPC node URL must be set")
 	MsgFailedRPCInitialization   = ffe("FF23052", "Failed to initialize blockchain RPC client")
+	MsgReplacementFeeExceedsMax  = ffe("FF23053", "Fee of %d mutez required to replace operation %s exceeds the max fee of %d mutez")
+	MsgInvalidTransactionOptions = ffe("FF23054", "Invalid transaction options: %s")
+	MsgOperationLimitExceedsMax  = ffe("FF23055", "Estimated %s of %d for the operation exceeds the maximum of %d")
+	MsgInvalidTransactionMethod  = ffe("FF23056", "Invalid transaction method: %s")
+	MsgInvalidBatchCall          = ffe("FF23057", "Invalid call %d of the batch: %s")
+	MsgBatchWithParams           = ffe("FF23058", "Params must be set on each call of a batch, rather than on the transaction")
+	MsgInvalidAmount             = ffe("FF23059", "Invalid amount of %s mutez")
+	MsgAmountMismatch            = ffe("FF23060", "Value of %s mutez does not match the total amount of %d mutez sent by the operation")
+	MsgInsufficientBalance       = ffe("FF23061", "Balance of %s is too low to pay for the operation: %s")
+	MsgUnknownSignerType         = ffe("FF23062", "Unknown signer type '%s'")
+	MsgKeystoreLoadFailed        = ffe("FF23063", "Failed to load keystore %s: %s")
+	MsgKeystoreKeyFailed         = ffe("FF23064", "Failed to load key '%s' from keystore %s: %s")
+	MsgKeyNotFound               = ffe("FF23065", "No key found for address %s")
+	MsgSignerRequestFailed       = ffe("FF23066", "Request '%s' to the signer failed: %s")
+	MsgSignerRPCError            = ffe("FF23067", "Signer returned an error for '%s': %d %s")
+	MsgSignerInvalidResult       = ffe("FF23068", "Invalid result from the signer for '%s': %s")
+	MsgSignerCannotListAddresses = ffe("FF23069", "Signer type '%s' cannot list its addresses")
+	MsgSignatoryRequestFailed    = ffe("FF23070", "Signatory request failed: %s")
+	MsgInvalidSignatoryAuthKey   = ffe("FF23071", "Invalid authentication key for the signatory: %s")
+	MsgMissingSignatoryURL       = ffe("FF23072", "URL of the signatory must be set at blockchain.signatory.url when the signer type is 'signatory'")
+	MsgInvalidPreparedTx         = ffe("FF23073", "Failed to parse prepared transaction data: %s")
+	MsgSignerMissingAddress      = ffe("FF23074", "Signer does not have the key of '%s'")
 )
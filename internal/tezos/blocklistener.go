@@ -13,6 +13,10 @@ import (
 	"github.com/trilitech/tzgo/rpc"
 )
 
+// indexQueueLength is the number of block updates that can wait to be indexed, before the blocks of further updates
+// are left for receipts to find by scanning back from the head of the chain
+const indexQueueLength = 50
+
 type blockUpdateConsumer struct {
 	id      *fftypes.UUID // could be an event stream ID for example - must be unique
 	ctx     context.Context
@@ -33,6 +37,9 @@ type blockListener struct {
 	blockPollingInterval       time.Duration
 	unstableHeadLength         int
 	canonicalChain             *list.List
+	canonicalHashes            map[int64]string // snapshot of the canonical chain for other routines to read, protected by mux
+	indexQueue                 chan []string    // hashes of new blocks, indexed in the background so they do not hold up notifications
+	indexLoopDone              chan struct{}
 }
 
 type minimalBlockInfo struct {
@@ -50,7 +57,9 @@ func newBlockListener(ctx context.Context, c *tezosConnector, conf config.Sectio
 		consumers:                  make(map[fftypes.UUID]*blockUpdateConsumer),
 		blockPollingInterval:       conf.GetDuration(BlockPollingInterval),
 		canonicalChain:             list.New(),
+		canonicalHashes:            make(map[int64]string),
 		unstableHeadLength:         int(c.checkpointBlockGap),
+		indexQueue:                 make(chan []string, indexQueueLength),
 	}
 	return bl
 }
@@ -65,7 +74,9 @@ func (bl *blockListener) addConsumer(c *blockUpdateConsumer) {
 func (bl *blockListener) checkStartedLocked() {
 	if bl.listenLoopDone == nil {
 		bl.listenLoopDone = make(chan struct{})
+		bl.indexLoopDone = make(chan struct{})
 		go bl.listenLoop()
+		go bl.indexLoop()
 	}
 }
 
@@ -91,10 +102,10 @@ func (bl *blockListener) listenLoop() {
 			return
 		}
 
-		isSuccessfullyReconnected, isGoingToNextBlockHeader := bl.reconnect(mon)
-		if !isSuccessfullyReconnected {
-			return
-		} else if isGoingToNextBlockHeader {
+		var ok bool
+		if mon, ok = bl.reconnect(mon); !ok {
+			gapPotential = true
+			failCount++
 			continue
 		}
 
@@ -113,6 +124,7 @@ func (bl *blockListener) listenLoop() {
 
 		update := &ffcapi.BlockHashEvent{GapPotential: gapPotential}
 		notifyPos := bl.getNotifyPosition(blockHead)
+		bl.snapshotCanonicalChain()
 		bl.notifyAndUpdate(notifyPos, update)
 
 		// Reset retry count when we have a full successful loop
@@ -139,37 +151,30 @@ func (bl *blockListener) handleFailCount(failCount int) bool {
 	return true
 }
 
-func (bl *blockListener) reconnect(mon *rpc.BlockHeaderMonitor) (bool, bool) {
-	if mon == nil {
-		mon = rpc.NewBlockHeaderMonitor()
-
-		// register the block monitor with our client
-		if err := bl.c.client.MonitorBlockHeader(bl.ctx, mon); err != nil {
-			mon.Close()
-			mon = nil
-			if ErrorStatus(err) == 404 {
-				log.L(bl.ctx).Errorf("monitor: event mode unsupported. %s", err.Error())
-			} else {
-				log.L(bl.ctx).Debugf("monitor: %s", err.Error())
+// reconnect returns the supplied block header monitor if it is still open, or registers a new one with the client
+func (bl *blockListener) reconnect(mon *rpc.BlockHeaderMonitor) (*rpc.BlockHeaderMonitor, bool) {
+	if mon != nil {
+		return mon, true
+	}
 
-				<-bl.ctx.Done()
-				return true, false
-			}
-			return false, true
+	mon = rpc.NewBlockHeaderMonitor()
+	// register the block monitor with our client
+	if err := bl.c.client.MonitorBlockHeader(bl.ctx, mon); err != nil {
+		mon.Close()
+		if ErrorStatus(err) == 404 {
+			log.L(bl.ctx).Errorf("monitor: event mode unsupported. %s", err.Error())
+		} else {
+			log.L(bl.ctx).Debugf("monitor: %s", err.Error())
 		}
+		return nil, false
 	}
-	return false, false
+	return mon, true
 }
 
+// getNotifyPosition reconciles a new block header with the canonical chain, returning the first block to notify
+// consumers about, or nil if the header did not change the chain
 func (bl *blockListener) getNotifyPosition(blockHead *rpc.BlockHeaderLogEntry) *list.Element {
-	var notifyPos *list.Element
-
-	candidate := bl.reconcileCanonicalChain(blockHead)
-	// Check this is the lowest position to notify from
-	if candidate != nil && (candidate.Value.(*minimalBlockInfo).number < notifyPos.Value.(*minimalBlockInfo).number) {
-		notifyPos = candidate
-	}
-	return notifyPos
+	return bl.reconcileCanonicalChain(blockHead)
 }
 
 func (bl *blockListener) notifyAndUpdate(notifyPos *list.Element, update *ffcapi.BlockHashEvent) {
@@ -180,6 +185,10 @@ func (bl *blockListener) notifyAndUpdate(notifyPos *list.Element, update *ffcapi
 			notifyPos = notifyPos.Next()
 		}
 
+		// Index the operations of the blocks in the background. Consumers looking for a receipt before its block
+		// is indexed find it by scanning back from the head of the chain instead.
+		bl.queueIndexBlocks(update.BlockHashes)
+
 		// Take a copy of the consumers in the lock
 		bl.mux.Lock()
 		consumers := make([]*blockUpdateConsumer, 0, len(bl.consumers))
@@ -372,7 +381,7 @@ func (bl *blockListener) trimToLastValidBlock() (lastValidBlock *minimalBlockInf
 			// Trim everything after this point, as it's invalidated
 			nextElem := lastElem.Next()
 			for nextElem != nil {
-				toRemove := lastElem
+				toRemove := nextElem
 				nextElem = nextElem.Next()
 				_ = bl.canonicalChain.Remove(toRemove)
 			}
@@ -384,6 +393,64 @@ func (bl *blockListener) trimToLastValidBlock() (lastValidBlock *minimalBlockInf
 	return lastValidBlock
 }
 
+// snapshotCanonicalChain records the hashes of the blocks in the canonical chain, as the chain itself is
+// only safe to access from the listen loop
+func (bl *blockListener) snapshotCanonicalChain() {
+	hashes := make(map[int64]string, bl.canonicalChain.Len())
+	for elem := bl.canonicalChain.Front(); elem != nil; elem = elem.Next() {
+		mbi := elem.Value.(*minimalBlockInfo)
+		hashes[mbi.number] = mbi.hash
+	}
+	bl.mux.Lock()
+	bl.canonicalHashes = hashes
+	bl.mux.Unlock()
+}
+
+// getCanonicalBlockHash returns the hash of the block at the supplied height in the canonical chain, if
+// that height is within the unstable head of the chain that we are tracking
+func (bl *blockListener) getCanonicalBlockHash(number int64) (string, bool) {
+	bl.mux.Lock()
+	defer bl.mux.Unlock()
+	hash, ok := bl.canonicalHashes[number]
+	return hash, ok
+}
+
+// queueIndexBlocks queues new blocks to be indexed, without waiting if the index loop is behind
+func (bl *blockListener) queueIndexBlocks(hashes []string) {
+	select {
+	case bl.indexQueue <- hashes:
+	default:
+		log.L(bl.ctx).Debugf("Index queue is full, so blocks %v are not indexed", hashes)
+	}
+}
+
+// indexLoop indexes the blocks queued by the listen loop, until the block listener is stopped
+func (bl *blockListener) indexLoop() {
+	defer close(bl.indexLoopDone)
+	for {
+		select {
+		case hashes := <-bl.indexQueue:
+			bl.indexBlocks(hashes)
+		case <-bl.ctx.Done():
+			log.L(bl.ctx).Debugf("Block index loop exiting")
+			return
+		}
+	}
+}
+
+// indexBlocks fetches new blocks and indexes their manager operations in the transaction cache. A block that
+// cannot be fetched is skipped, as receipts can still be found by scanning back from the head of the chain.
+func (bl *blockListener) indexBlocks(hashes []string) {
+	for _, hash := range hashes {
+		block, err := bl.c.getBlockInfoByHash(bl.ctx, hash)
+		if err != nil || block == nil {
+			log.L(bl.ctx).Debugf("Block %s could not be fetched to index its operations: %v", hash, err)
+			continue
+		}
+		bl.c.indexBlockOperations(block)
+	}
+}
+
 func (bl *blockListener) dispatchToConsumers(consumers []*blockUpdateConsumer, update *ffcapi.BlockHashEvent) {
 	for _, c := range consumers {
 		log.L(bl.ctx).Tracef("Notifying consumer %s of blocks %v (gap=%t)", c.id, update.BlockHashes, update.GapPotential)
@@ -1,11 +1,16 @@
 package tezos
 
 import (
+	"container/list"
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/trilitech/tzgo/rpc"
 	"github.com/trilitech/tzgo/tezos"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -45,3 +50,138 @@ func TestBlockListenerStartGettingHighestBlockFailBeforeStop(t *testing.T) {
 
 	mRPC.AssertExpectations(t)
 }
+
+func TestBlockListenerTrimToLastValidBlock(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	bl := c.blockListener
+
+	hashes := make([]tezos.BlockHash, 4)
+	for i := range hashes {
+		hashes[i] = tezos.NewBlockHash([]byte{31: byte(i + 1)})
+	}
+	for i := int64(1); i <= 3; i++ {
+		bl.canonicalChain.PushBack(&minimalBlockInfo{number: i, hash: hashes[i-1].String()})
+	}
+	bl.snapshotCanonicalChain()
+
+	mockBlockLevel(mRPC, 3).Return(&rpc.Block{Hash: hashes[3], Header: rpc.BlockHeader{Level: 3}}, nil)
+	mockBlockLevel(mRPC, 2).Return(&rpc.Block{Hash: hashes[1], Header: rpc.BlockHeader{Level: 2}}, nil)
+
+	lastValid := bl.trimToLastValidBlock()
+	assert.Equal(t, int64(2), lastValid.number)
+	assert.Equal(t, 2, bl.canonicalChain.Len())
+
+	hash, ok := bl.getCanonicalBlockHash(3)
+	assert.True(t, ok)
+	assert.Equal(t, hashes[2].String(), hash)
+	bl.snapshotCanonicalChain()
+	_, ok = bl.getCanonicalBlockHash(3)
+	assert.False(t, ok)
+
+	mRPC.AssertExpectations(t)
+}
+
+func TestBlockListenerIndexBlocks(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	bl := c.blockListener
+
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1)})
+	missing := tezos.NewBlockHash([]byte{31: 1})
+	mRPC.On("GetBlock", mock.Anything, block.Hash).Return(block, nil)
+	mRPC.On("GetBlock", mock.Anything, missing).Return(nil, errors.New("pop"))
+
+	bl.indexBlocks([]string{missing.String(), block.Hash.String()})
+	assert.True(t, c.txCache.Contains(testOpHash(1).String()))
+
+	mRPC.AssertExpectations(t)
+}
+
+func TestBlockListenerNotifiesBeforeIndexing(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	bl := c.blockListener
+
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1)})
+	fetched := make(chan struct{})
+	mRPC.On("GetHeadBlock", mock.Anything).Return(block, nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	// The node is slow to return the block, which must not hold up the notification
+	mRPC.On("GetBlock", mock.Anything, block.Hash).Run(func(args mock.Arguments) {
+		<-fetched
+	}).Return(block, nil)
+
+	updates := make(chan *ffcapi.BlockHashEvent)
+	bl.addConsumer(&blockUpdateConsumer{
+		id:      fftypes.NewUUID(),
+		ctx:     context.Background(),
+		updates: updates,
+	})
+	notifyPos := list.New().PushBack(&minimalBlockInfo{number: 12345, hash: block.Hash.String()})
+	go bl.notifyAndUpdate(notifyPos, &ffcapi.BlockHashEvent{})
+
+	update := <-updates
+	assert.Equal(t, []string{block.Hash.String()}, update.BlockHashes)
+	assert.False(t, c.txCache.Contains(testOpHash(1).String()))
+
+	close(fetched)
+	assert.Eventually(t, func() bool {
+		return c.txCache.Contains(testOpHash(1).String())
+	}, time.Second, time.Millisecond)
+}
+
+func TestBlockListenerIndexQueueFull(t *testing.T) {
+	_, c, _, done := newTestConnector(t)
+	defer done()
+	bl := c.blockListener
+
+	// Nothing is reading the queue, so the blocks are dropped rather than waiting
+	bl.indexQueue = make(chan []string)
+	bl.queueIndexBlocks([]string{testBlockWithOps(12345).Hash.String()})
+}
+
+func TestBlockListenerReconnect(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	bl := c.blockListener
+	bl.blockPollingInterval = 1 * time.Millisecond
+	c.retry.InitialDelay = 1 * time.Millisecond
+	c.retry.MaximumDelay = 1 * time.Millisecond
+
+	hashes := make([]tezos.BlockHash, 3)
+	for i := range hashes {
+		hashes[i] = tezos.NewBlockHash([]byte{31: byte(i + 1)})
+	}
+	// each connection delivers one block header, and is then dropped by the node
+	sendHeader := func(level int64) func(args mock.Arguments) {
+		return func(args mock.Arguments) {
+			mon := args[1].(*rpc.BlockHeaderMonitor)
+			go func() {
+				mon.Send(bl.ctx, &rpc.BlockHeaderLogEntry{Level: level, Hash: hashes[level], Predecessor: hashes[level-1]})
+				mon.Err(errors.New("connection reset"))
+			}()
+		}
+	}
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(0), nil)
+	mRPC.On("GetBlock", mock.Anything, mock.Anything).Return(nil, errors.New("pop")).Maybe()
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(errors.New("status 404")).Once()
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Run(sendHeader(1)).Return(nil).Once()
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Run(sendHeader(2)).Return(nil).Once()
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	updates := make(chan *ffcapi.BlockHashEvent)
+	bl.addConsumer(&blockUpdateConsumer{
+		id:      fftypes.NewUUID(),
+		ctx:     context.Background(),
+		updates: updates,
+	})
+
+	// The listener keeps reconnecting after a failed connection and after a dropped one, and flags the potential gap
+	for level := 1; level <= 2; level++ {
+		update := <-updates
+		assert.Equal(t, []string{hashes[level].String()}, update.BlockHashes)
+		assert.True(t, update.GapPotential)
+	}
+	assert.Equal(t, int64(2), bl.getHighestBlock(bl.ctx))
+}
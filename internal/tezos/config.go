@@ -15,15 +15,34 @@ const (
 	EventsCheckpointBlockGap    = "events.checkpointBlockGap"
 	EventsBlockTimestamps       = "events.blockTimestamps"
 	EventsFilterPollingInterval = "events.filterPollingInterval"
+	FeesBumpPercent             = "fees.bumpPercent"
+	FeesMaxFee                  = "fees.maxFee"
+	FeesMinimalFee              = "fees.minimalFee"
+	FeesNanotezPerByte          = "fees.nanotezPerByte"
+	FeesNanotezPerGasUnit       = "fees.nanotezPerGasUnit"
+	LimitsMaxGasLimit           = "limits.maxGasLimit"
+	LimitsMaxStorageLimit       = "limits.maxStorageLimit"
+	LimitsSafetyMargin          = "limits.safetyMargin"
+	ReceiptsMaxScanDepth        = "receipts.maxScanDepth"
+	SignerType                  = "signer.type"
+	SignerKeystorePath          = "signer.keystore.path"
+	SignerKeystorePasswordFile  = "signer.keystore.passwordFile"
+	SignerJSONRPC               = "signer.jsonrpc"
 	RetryInitDelay              = "retry.initialDelay"
 	RetryMaxDelay               = "retry.maxDelay"
 	RetryFactor                 = "retry.factor"
 	TxCacheSize                 = "txCacheSize"
+	PublicKeyCacheSize          = "publicKeyCacheSize"
 	BlockchainRPC               = "blockchain.rpc"
 	BlockchainNetwork           = "blockchain.network"
 	BlockchainSignatory         = "blockchain.signatory"
 )
 
+const (
+	// SignatoryAuthKey is in the blockchain.signatory section, with its HTTP client config
+	SignatoryAuthKey = "authKey"
+)
+
 const (
 	DefaultListenerPort        = 5102
 	DefaultGasEstimationFactor = 1.5
@@ -32,6 +51,13 @@ const (
 	DefaultEventsCatchupThreshold   = 500
 	DefaultEventsCheckpointBlockGap = 50
 
+	DefaultFeesBumpPercent = 10.0
+	DefaultFeesMaxFee      = 1000000
+
+	DefaultLimitsSafetyMargin = 100
+
+	DefaultReceiptsMaxScanDepth = 20
+
 	DefaultRetryInitDelay   = "100ms"
 	DefaultRetryMaxDelay    = "30s"
 	DefaultRetryDelayFactor = 2.0
@@ -48,11 +74,27 @@ func InitConfig(conf config.Section) {
 	conf.AddKnownKey(EventsCatchupPageSize, DefaultCatchupPageSize)
 	conf.AddKnownKey(EventsCatchupThreshold, DefaultEventsCatchupThreshold)
 	conf.AddKnownKey(EventsCheckpointBlockGap, DefaultEventsCheckpointBlockGap)
+	conf.AddKnownKey(FeesBumpPercent, DefaultFeesBumpPercent)
+	conf.AddKnownKey(FeesMaxFee, DefaultFeesMaxFee)
+	conf.AddKnownKey(FeesMinimalFee)
+	conf.AddKnownKey(FeesNanotezPerByte)
+	conf.AddKnownKey(FeesNanotezPerGasUnit)
+	conf.AddKnownKey(LimitsMaxGasLimit, 0)
+	conf.AddKnownKey(LimitsMaxStorageLimit, 0)
+	conf.AddKnownKey(LimitsSafetyMargin, DefaultLimitsSafetyMargin)
+	conf.AddKnownKey(ReceiptsMaxScanDepth, DefaultReceiptsMaxScanDepth)
+	conf.AddKnownKey(SignerType, signerTypeSignatory)
+	conf.AddKnownKey(SignerKeystorePath)
+	conf.AddKnownKey(SignerKeystorePasswordFile)
+	ffresty.InitConfig(conf.SubSection(SignerJSONRPC))
 	conf.AddKnownKey(RetryFactor, DefaultRetryDelayFactor)
 	conf.AddKnownKey(RetryInitDelay, DefaultRetryInitDelay)
 	conf.AddKnownKey(RetryMaxDelay, DefaultRetryMaxDelay)
 	conf.AddKnownKey(TxCacheSize, 250)
+	conf.AddKnownKey(PublicKeyCacheSize, 250)
 	conf.AddKnownKey(BlockchainRPC)
 	conf.AddKnownKey(BlockchainNetwork, "mainnet")
-	conf.AddKnownKey(BlockchainSignatory)
+	signatoryConf := conf.SubSection(BlockchainSignatory)
+	ffresty.InitConfig(signatoryConf)
+	signatoryConf.AddKnownKey(SignatoryAuthKey)
 }
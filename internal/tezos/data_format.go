@@ -0,0 +1,78 @@
+package tezos
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-tezosconnect/internal/msgs"
+	"github.com/trilitech/tzgo/micheline"
+)
+
+// dataFormat is the JSON shape of the Michelson values we deliver, such as event payloads
+type dataFormat string
+
+const (
+	// dataFormatMap is a JSON object keyed by the field annotations (or the field position where not annotated)
+	dataFormatMap dataFormat = "map"
+	// dataFormatFlatArray is a JSON array of the field values, in the order they are declared in the type
+	dataFormatFlatArray dataFormat = "flat_array"
+	// dataFormatSelfDescribing is a JSON array of objects containing the name, type and value of each field
+	dataFormatSelfDescribing dataFormat = "self_describing"
+)
+
+var dataFormats = []dataFormat{dataFormatMap, dataFormatFlatArray, dataFormatSelfDescribing}
+
+type selfDescribingField struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+func parseDataFormat(ctx context.Context, s string) (dataFormat, error) {
+	names := make([]string, len(dataFormats))
+	for i, df := range dataFormats {
+		if string(df) == s {
+			return df, nil
+		}
+		names[i] = string(df)
+	}
+	return "", i18n.NewError(ctx, msgs.MsgBadDataFormat, s, strings.Join(names, ","))
+}
+
+// formatValue decodes a Micheline value using its Michelson type, into the JSON structure for the data format.
+// Pairs are decoded as a set of fields, which are named using the annotations in the type.
+func (df dataFormat) formatValue(typ micheline.Prim, value micheline.Prim) (interface{}, error) {
+	t := micheline.NewType(typ)
+	v, err := micheline.NewValuePtr(t, value).Map()
+	if err != nil {
+		return nil, err
+	}
+	if df == dataFormatMap {
+		return v, nil
+	}
+
+	// For the array formats, a pair is a list of fields and anything else is a single field
+	td := t.Typedef("value")
+	fields := []micheline.Typedef{td}
+	values := []interface{}{v}
+	if m, isMap := v.(map[string]interface{}); isMap && td.Type == micheline.TypeStruct {
+		fields = td.Args
+		values = make([]interface{}, len(td.Args))
+		for i, f := range td.Args {
+			values[i] = m[f.Name]
+		}
+	}
+	if df == dataFormatFlatArray {
+		return values, nil
+	}
+	described := make([]*selfDescribingField, len(fields))
+	for i, f := range fields {
+		described[i] = &selfDescribingField{
+			Name:  f.Name,
+			Type:  f.Type,
+			Value: values[i],
+		}
+	}
+	return described, nil
+}
@@ -0,0 +1,51 @@
+package tezos
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trilitech/tzgo/micheline"
+)
+
+const testTransferType = `{"prim":"pair","args":[{"prim":"address","annots":["%from"]},{"prim":"pair","args":[{"prim":"nat","annots":["%amount"]},{"prim":"string","annots":["%memo"]}]}]}`
+const testTransferValue = `{"prim":"Pair","args":[{"string":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"},{"int":"5"},{"string":"hi"}]}`
+
+func testFormatValue(t *testing.T, df dataFormat, typeJSON, valueJSON string) string {
+	var typ, value micheline.Prim
+	assert.NoError(t, json.Unmarshal([]byte(typeJSON), &typ))
+	assert.NoError(t, json.Unmarshal([]byte(valueJSON), &value))
+	v, err := df.formatValue(typ, value)
+	assert.NoError(t, err)
+	b, err := json.Marshal(v)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestFormatValueMap(t *testing.T) {
+	assert.JSONEq(t, `{"from":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN","amount":"5","memo":"hi"}`,
+		testFormatValue(t, dataFormatMap, testTransferType, testTransferValue))
+	assert.JSONEq(t, `{"0":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN","1":"5"}`,
+		testFormatValue(t, dataFormatMap, `{"prim":"pair","args":[{"prim":"address"},{"prim":"nat"}]}`, `{"prim":"Pair","args":[{"string":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"},{"int":"5"}]}`))
+	assert.JSONEq(t, `"5"`, testFormatValue(t, dataFormatMap, `{"prim":"nat"}`, `{"int":"5"}`))
+}
+
+func TestFormatValueFlatArray(t *testing.T) {
+	assert.JSONEq(t, `["tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN","5","hi"]`,
+		testFormatValue(t, dataFormatFlatArray, testTransferType, testTransferValue))
+	assert.JSONEq(t, `[["5","6"]]`, testFormatValue(t, dataFormatFlatArray, `{"prim":"list","args":[{"prim":"nat"}]}`, `[{"int":"5"},{"int":"6"}]`))
+}
+
+func TestFormatValueSelfDescribing(t *testing.T) {
+	assert.JSONEq(t, `[
+		{"name":"from","type":"address","value":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"},
+		{"name":"amount","type":"nat","value":"5"},
+		{"name":"memo","type":"string","value":"hi"}
+	]`, testFormatValue(t, dataFormatSelfDescribing, testTransferType, testTransferValue))
+	assert.JSONEq(t, `[{"name":"value","type":"nat","value":"5"}]`, testFormatValue(t, dataFormatSelfDescribing, `{"prim":"nat"}`, `{"int":"5"}`))
+}
+
+func TestFormatValueMismatch(t *testing.T) {
+	_, err := dataFormatMap.formatValue(micheline.NewCode(micheline.T_PAIR, micheline.NewCode(micheline.T_NAT), micheline.NewCode(micheline.T_NAT)), micheline.NewString("wrong"))
+	assert.Error(t, err)
+}
@@ -2,7 +2,6 @@ package tezos
 
 import (
 	"context"
-	"encoding/hex"
 	"encoding/json"
 
 	"github.com/hyperledger/firefly-common/pkg/i18n"
@@ -22,8 +21,13 @@ func (c *tezosConnector) DeployContractPrepare(ctx context.Context, req *ffcapi.
 
 	var sc micheline.Script
 	_ = json.Unmarshal([]byte(req.Contract.String()), &sc)
+	balance, err := parseAmount(ctx, req.Value)
+	if err != nil {
+		return nil, ffcapi.ErrorReasonInvalidInputs, err
+	}
 	orig := &codec.Origination{
-		Script: sc,
+		Script:  sc,
+		Balance: balance,
 	}
 
 	addr, err := tezos.ParseAddress(req.From)
@@ -37,12 +41,12 @@ func (c *tezosConnector) DeployContractPrepare(ctx context.Context, req *ffcapi.
 		WithSource(addr).
 		WithBranch(headBlockHash)
 
-	err = c.completeOp(ctx, op, req.From, req.Nonce)
+	_, err = c.completeOp(ctx, op, req.From, req.Nonce)
 	if err != nil {
 		return nil, ffcapi.ErrorReasonInvalidInputs, err
 	}
-	opts := &rpc.DefaultOptions
-	if reason, err := c.estimateAndAssignTxCost(ctx, op, opts); err != nil {
+	limits := c.txLimits
+	if reason, err := c.estimateAndAssignTxCost(ctx, op, &limits); err != nil {
 		return nil, reason, err
 	}
 
@@ -50,6 +54,6 @@ func (c *tezosConnector) DeployContractPrepare(ctx context.Context, req *ffcapi.
 
 	return &ffcapi.TransactionPrepareResponse{
 		Gas:             req.Gas,
-		TransactionData: hex.EncodeToString(op.Bytes()),
+		TransactionData: encodePreparedTransaction(op, &limits),
 	}, "", nil
 }
@@ -133,3 +133,20 @@ func TestDeployContractPrepareParseAddressError(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, reason, ffcapi.ErrorReasonInvalidInputs)
 }
+
+func TestDeployContractPrepareInvalidValueError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	resp, reason, err := c.DeployContractPrepare(ctx, &ffcapi.ContractDeployPrepareRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From:  "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+			Value: fftypes.NewFFBigInt(-1),
+		},
+		Contract: fftypes.JSONAnyPtr("{\"code\":[{\"args\":[{\"prim\":\"string\"}],\"prim\":\"parameter\"},{\"args\":[{\"prim\":\"string\"}],\"prim\":\"storage\"},{\"args\":[[{\"prim\":\"CAR\"},{\"args\":[{\"prim\":\"operation\"}],\"prim\":\"NIL\"},{\"prim\":\"PAIR\"}]],\"prim\":\"code\"}],\"storage\":{\"string\":\"hello\"}}"),
+	})
+
+	assert.Nil(t, resp)
+	assert.Regexp(t, "FF23059", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+}
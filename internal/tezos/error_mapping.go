@@ -31,16 +31,27 @@ func mapError(methodType tezosRPCMethodCategory, err error) ffcapi.ErrorReason {
 		if strings.Contains(errString, "script_rejected") {
 			return ffcapi.ErrorReasonTransactionReverted
 		}
+		if isInsufficientBalance(errString) {
+			return ffcapi.ErrorReasonInsufficientFunds
+		}
 	case sendRPCMethods:
 		if strings.Contains(errString, "counter_in_the_past") {
 			return ffcapi.ErrorReasonNonceTooLow
 		}
+		if isInsufficientBalance(errString) {
+			return ffcapi.ErrorReasonInsufficientFunds
+		}
 	}
 
 	// Best default in FFCAPI is to provide no mapping
 	return ""
 }
 
+// isInsufficientBalance checks for the errors of an account that cannot pay the amount, fees or storage of an operation
+func isInsufficientBalance(errString string) bool {
+	return strings.Contains(errString, "balance_too_low") || strings.Contains(errString, "cannot_pay_storage_fee")
+}
+
 func ErrorStatus(err error) int {
 	switch e := err.(type) {
 	case *httpError:
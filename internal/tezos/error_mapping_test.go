@@ -27,6 +27,18 @@ func TestMapError(t *testing.T) {
 			err:         errors.New("counter_in_the_past"),
 			errorReason: ffcapi.ErrorReasonNonceTooLow,
 		},
+		{
+			name:        "CallRPCMethods with balance_too_low error",
+			methodType:  callRPCMethods,
+			err:         errors.New("proto.019-PtParisB.contract.balance_too_low"),
+			errorReason: ffcapi.ErrorReasonInsufficientFunds,
+		},
+		{
+			name:        "SendRPCMethods with cannot_pay_storage_fee error",
+			methodType:  sendRPCMethods,
+			err:         errors.New("proto.019-PtParisB.contract.cannot_pay_storage_fee"),
+			errorReason: ffcapi.ErrorReasonInsufficientFunds,
+		},
 		{
 			name:        "BlockRPCMethods with some non 404 Status error",
 			methodType:  blockRPCMethods,
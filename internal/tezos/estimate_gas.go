@@ -2,10 +2,82 @@ package tezos
 
 import (
 	"context"
+	"math"
 
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
 	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/trilitech/tzgo/codec"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
 )
 
-func (c *tezosConnector) GasEstimate(_ context.Context, _ *ffcapi.TransactionInput) (*ffcapi.GasEstimateResponse, ffcapi.ErrorReason, error) {
-	return nil, "", nil
+// GasEstimate simulates the operation to find the gas it consumes, and returns the gas limit to use for it.
+// The gas estimation factor is applied to the simulated gas and storage, to allow for the state of the chain
+// changing before the operation is included. The gas limit is summed over all the contents of the operation
+// (including any reveal), as that is what the operation is charged for.
+func (c *tezosConnector) GasEstimate(ctx context.Context, tx *ffcapi.TransactionInput) (*ffcapi.GasEstimateResponse, ffcapi.ErrorReason, error) {
+	method, err := parseTransactionMethod(ctx, tx.Method)
+	if err != nil {
+		return nil, ffcapi.ErrorReasonInvalidInputs, err
+	}
+
+	contents, err := c.buildTransactions(ctx, tx, method)
+	if err != nil {
+		return nil, ffcapi.ErrorReasonInvalidInputs, err
+	}
+
+	op, err := c.buildOp(ctx, contents, tx.From, tx.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sim, reason, err := c.callTransaction(ctx, op, nil)
+	if err != nil {
+		return nil, reason, err
+	}
+
+	// The fee depends on the limits, so is calculated with the limits we would submit the operation with
+	op.WithLimits(c.applyGasEstimationFactor(op, sim.MinLimits()), 0)
+	limits := op.Limits()
+	log.L(ctx).Infof("Gas estimate milligas(sim)=%d gas_limit=%d storage_limit=%d fee=%d", simulatedMilliGas(sim), limits.GasLimit, limits.StorageLimit, limits.Fee)
+
+	return &ffcapi.GasEstimateResponse{
+		GasEstimate: fftypes.NewFFBigInt(limits.GasLimit),
+	}, "", nil
+}
+
+// applyGasEstimationFactor scales up the simulated limits of each content of the operation,
+// within the hard limits that the protocol allows for a single operation
+func (c *tezosConnector) applyGasEstimationFactor(op *codec.Op, simulated []tezos.Limits) []tezos.Limits {
+	limits := make([]tezos.Limits, len(simulated))
+	for i, l := range simulated {
+		limits[i] = tezos.Limits{
+			Fee:          l.Fee,
+			GasLimit:     scaleLimit(l.GasLimit, c.gasEstimationFactor, op.Params.HardGasLimitPerOperation),
+			StorageLimit: scaleLimit(l.StorageLimit, c.gasEstimationFactor, op.Params.HardStorageLimitPerOperation),
+		}
+	}
+	return limits
+}
+
+func scaleLimit(value int64, factor float64, hardLimit int64) int64 {
+	scaled := int64(math.Ceil(float64(value) * factor))
+	if hardLimit > 0 && scaled > hardLimit {
+		return hardLimit
+	}
+	return scaled
+}
+
+// simulatedMilliGas is the total milligas consumed by the simulated operation, including internal operations
+func simulatedMilliGas(sim *rpc.Receipt) int64 {
+	var milligas int64
+	for _, content := range sim.Op.Contents {
+		meta := content.Meta()
+		milligas += meta.Result.MilliGas()
+		for _, ir := range meta.InternalResults {
+			milligas += ir.Result.MilliGas()
+		}
+	}
+	return milligas
 }
@@ -1,18 +1,119 @@
 package tezos
 
 import (
+	"errors"
 	"testing"
 
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
 	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
 )
 
+func testGasEstimateInput() *ffcapi.TransactionInput {
+	return &ffcapi.TransactionInput{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+			To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+		},
+		Method: fftypes.JSONAnyPtr("\"pause\""),
+		Params: []*fftypes.JSONAny{
+			fftypes.JSONAnyPtr("{\"entrypoint\":\"pause\",\"value\":{\"prim\":\"True\"}}"),
+		},
+	}
+}
+
 func TestGasEstimate(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+	mRPC.On("Simulate", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.Receipt{
+			Op: &rpc.Operation{
+				Contents: []rpc.TypedOperation{
+					rpc.Transaction{
+						Manager: rpc.Manager{
+							Generic: rpc.Generic{
+								Metadata: rpc.OperationMetadata{
+									Result: rpc.OperationResult{
+										Status:           tezos.OpStatusApplied,
+										ConsumedMilliGas: 1000500,
+										StorageSize:      100,
+									},
+									InternalResults: []*rpc.InternalResult{{
+										Result: rpc.OperationResult{
+											Status:           tezos.OpStatusApplied,
+											ConsumedMilliGas: 99500,
+										},
+									}},
+								},
+							},
+						},
+					},
+				},
+			},
+		}, nil)
+
+	resp, reason, err := c.GasEstimate(ctx, testGasEstimateInput())
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+	// 1001 + 100 gas used by the transaction and its internal operation (each rounded up), with the default factor of 1.5 applied
+	assert.Equal(t, int64(1652), resp.GasEstimate.Int64())
+}
+
+func TestGasEstimateCappedAtHardLimit(t *testing.T) {
+	assert.Equal(t, int64(150), scaleLimit(100, 1.5, 1040000))
+	assert.Equal(t, int64(1040000), scaleLimit(1000000, 1.5, 1040000))
+	assert.Equal(t, int64(0), scaleLimit(0, 1.5, 60000))
+}
+
+func TestGasEstimateSimulateError(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+	mRPC.On("Simulate", ctx, mock.Anything, mock.Anything).
+		Return(nil, errors.New("error"))
+
+	resp, _, err := c.GasEstimate(ctx, testGasEstimateInput())
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+}
+
+func TestGasEstimateWrongParamsError(t *testing.T) {
 	ctx, c, _, done := newTestConnector(t)
 	defer done()
 
-	resp, reason, err := c.GasEstimate(ctx, &ffcapi.TransactionInput{})
+	tx := testGasEstimateInput()
+	tx.Params = []*fftypes.JSONAny{fftypes.JSONAnyPtr("wrong")}
+	resp, reason, err := c.GasEstimate(ctx, tx)
+	assert.Regexp(t, "FF23014", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+	assert.Nil(t, resp)
+}
+
+func TestGasEstimateWrongToAddressError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	tx := testGasEstimateInput()
+	tx.To = "wrong"
+	resp, _, err := c.GasEstimate(ctx, tx)
+	assert.Regexp(t, "FF23020", err)
 	assert.Nil(t, resp)
-	assert.Empty(t, reason)
-	assert.NoError(t, err)
 }
@@ -0,0 +1,160 @@
+package tezos
+
+import (
+	"context"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/trilitech/tzgo/micheline"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+// Manager operations are the only ones that can call smart contracts, and hence emit events
+const managerOperationsPass = 3
+
+// eventInfo is the Tezos specific information delivered alongside each event
+type eventInfo struct {
+	BlockHash        string `json:"blockHash"`
+	BlockNumber      int64  `json:"blockNumber"`
+	TransactionHash  string `json:"transactionHash"`
+	TransactionIndex int64  `json:"transactionIndex"`
+	LogIndex         int64  `json:"logIndex"`
+	Address          string `json:"address"`
+	Tag              string `json:"tag,omitempty"`
+}
+
+// getBlockRangeEvents scans the blocks in the supplied range (inclusive) for events matching the supplied listeners,
+// returning the events in block/operation order along with the blocks that were scanned. The scan stops early
+// without error if we reach the head of the chain.
+func (es *eventStream) getBlockRangeEvents(ctx context.Context, listeners []*listener, fromBlock, toBlock int64) ([]*ffcapi.ListenerEvent, []*minimalBlockInfo, error) {
+	events := make([]*ffcapi.ListenerEvent, 0)
+	blocks := make([]*minimalBlockInfo, 0)
+	for blockNumber := fromBlock; blockNumber <= toBlock; blockNumber++ {
+		block, reason, err := es.c.getCanonicalBlock(ctx, blockNumber)
+		if err != nil {
+			if reason == ffcapi.ErrorReasonNotFound {
+				break
+			}
+			return nil, nil, err
+		}
+		if block == nil {
+			break
+		}
+		blockEvents, err := es.getBlockEvents(ctx, listeners, block)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, blockEvents...)
+		blocks = append(blocks, &minimalBlockInfo{
+			number:     block.GetLevel(),
+			hash:       block.Hash.String(),
+			parentHash: block.Header.Predecessor.String(),
+		})
+	}
+	return events, blocks, nil
+}
+
+// getBlockEvents finds the events for the supplied listeners in a single block. Every operation result of the
+// manager operations in the block is a potential event, whether it is one of the contents of an operation group,
+// or an internal result of one of those contents (such as an event emitted via the Michelson EMIT instruction).
+func (es *eventStream) getBlockEvents(ctx context.Context, listeners []*listener, block *rpc.Block) ([]*ffcapi.ListenerEvent, error) {
+	events := make([]*ffcapi.ListenerEvent, 0)
+	if len(block.Operations) <= managerOperationsPass {
+		return events, nil
+	}
+	// The block was fetched (through the block cache) to find the events, so the timestamp is available without
+	// any further lookup - we just leave it out if it's not wanted
+	var timestamp *fftypes.FFTime
+	if es.c.eventBlockTimestamps {
+		ts := fftypes.FFTime(block.Header.Timestamp)
+		timestamp = &ts
+	}
+	// The storage left by the calls to each contract so far in the block, to find the calls that change it
+	blockStorage := make(map[string]micheline.Prim)
+	for opIndex, op := range block.Operations[managerOperationsPass] {
+		// The result index is the position of the operation result within the whole operation group,
+		// counting each content and each of its internal results in order
+		resultIndex := int64(-1)
+		for _, content := range op.Contents {
+			contentResult := newContentResult(content)
+			results := []*operationResult{contentResult}
+			for _, ir := range content.Meta().InternalResults {
+				results = append(results, newInternalResult(contentResult, ir))
+			}
+			for _, r := range results {
+				resultIndex++
+				cp := &listenerCheckpoint{
+					Block:          block.GetLevel(),
+					BlockHash:      block.Hash.String(),
+					OperationPass:  managerOperationsPass,
+					OperationIndex: int64(opIndex),
+					ResultIndex:    resultIndex,
+				}
+				for _, l := range listeners {
+					if !l.isPending(cp) {
+						continue
+					}
+					if l.needsEntrypoint(r) {
+						if err := es.resolveEntrypoint(ctx, r); err != nil {
+							return nil, err
+						}
+					}
+					if l.needsStorageChange(r) {
+						if err := es.resolveStorageChange(ctx, r, block.GetLevel(), blockStorage); err != nil {
+							return nil, err
+						}
+					}
+					f := l.matches(r)
+					if f == nil {
+						continue
+					}
+					log.L(ctx).Debugf("Listener %s matched %s filter for %s result in operation %s", l.id, f.Kind, r.kind, op.Hash)
+					data, err := es.buildEventData(ctx, block, f, r)
+					if err != nil {
+						return nil, err
+					}
+					events = append(events, l.buildEvent(op, cp, f, r, data, timestamp))
+				}
+				if r.result.Storage != nil && r.result.IsSuccess() {
+					blockStorage[r.destination.String()] = *r.result.Storage
+				}
+			}
+		}
+	}
+	return events, nil
+}
+
+func (l *listener) buildEvent(op *rpc.Operation, cp *listenerCheckpoint, f *eventFilter, r *operationResult, data []byte, timestamp *fftypes.FFTime) *ffcapi.ListenerEvent {
+	info := &eventInfo{
+		BlockHash:        cp.BlockHash,
+		BlockNumber:      cp.Block,
+		TransactionHash:  op.Hash.String(),
+		TransactionIndex: cp.OperationIndex,
+		LogIndex:         cp.ResultIndex,
+		Address:          r.contractAddress(),
+	}
+	signature := f.Kind
+	if r.kind == tezos.OpTypeEvent {
+		info.Tag = r.internal.Tag
+		signature = r.internal.Tag
+	}
+	return &ffcapi.ListenerEvent{
+		Checkpoint: cp,
+		Event: &ffcapi.Event{
+			ID: ffcapi.EventID{
+				ListenerID:       l.id,
+				Signature:        signature,
+				BlockHash:        cp.BlockHash,
+				BlockNumber:      fftypes.FFuint64(cp.Block),
+				TransactionHash:  info.TransactionHash,
+				TransactionIndex: fftypes.FFuint64(cp.OperationIndex),
+				LogIndex:         fftypes.FFuint64(cp.ResultIndex),
+				Timestamp:        timestamp,
+			},
+			Info: info,
+			Data: fftypes.JSONAnyPtrBytes(data),
+		},
+	}
+}
@@ -25,6 +25,7 @@ func (c *tezosConnector) EventStreamStart(ctx context.Context, req *ffcapi.Event
 		events:         req.EventStream,
 		headBlock:      -1,
 		listeners:      make(map[fftypes.UUID]*listener),
+		recentBlocks:   make(map[int64]*scannedBlock),
 		streamLoopDone: make(chan struct{}),
 	}
 
@@ -98,26 +99,88 @@ func (c *tezosConnector) EventStreamStopped(ctx context.Context, req *ffcapi.Eve
 }
 
 // EventListenerVerifyOptions validates the configuration options for a listener, applying any defaults needed by the connector, and returning the update options for FFTM to persist
-func (c *tezosConnector) EventListenerVerifyOptions(_ context.Context, _ *ffcapi.EventListenerVerifyOptionsRequest) (*ffcapi.EventListenerVerifyOptionsResponse, ffcapi.ErrorReason, error) {
-	return nil, "", nil
+func (c *tezosConnector) EventListenerVerifyOptions(ctx context.Context, req *ffcapi.EventListenerVerifyOptionsRequest) (*ffcapi.EventListenerVerifyOptionsResponse, ffcapi.ErrorReason, error) {
+	l, err := c.buildListener(ctx, &ffcapi.EventListenerAddRequest{
+		EventListenerOptions: req.EventListenerOptions,
+	})
+	if err != nil {
+		return nil, ffcapi.ErrorReasonInvalidInputs, err
+	}
+
+	return &ffcapi.EventListenerVerifyOptionsResponse{
+		ResolvedSignature: l.config.signature,
+		ResolvedOptions:   *l.resolvedOptions(),
+	}, "", nil
 }
 
 // EventListenerAdd begins/resumes listening on set of events that must be consistently ordered. Blockchain specific signatures of the events are included, along with initial conditions (initial block number etc.), and the last stored checkpoint (if any)
-func (c *tezosConnector) EventListenerAdd(_ context.Context, _ *ffcapi.EventListenerAddRequest) (*ffcapi.EventListenerAddResponse, ffcapi.ErrorReason, error) {
-	return nil, "", nil
+func (c *tezosConnector) EventListenerAdd(ctx context.Context, req *ffcapi.EventListenerAddRequest) (*ffcapi.EventListenerAddResponse, ffcapi.ErrorReason, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	es := c.eventStreams[*req.StreamID]
+	if es == nil {
+		return nil, ffcapi.ErrorReasonNotFound, i18n.NewError(ctx, msgs.MsgStreamNotStarted, req.StreamID)
+	}
+	l, err := es.addEventListener(ctx, req)
+	if err != nil {
+		return nil, ffcapi.ErrorReasonInvalidInputs, err
+	}
+	// We start this listener straight away
+	es.startEventListener(l)
+	return &ffcapi.EventListenerAddResponse{}, "", nil
 }
 
 // EventListenerRemove ends listening on a set of events previous started
-func (c *tezosConnector) EventListenerRemove(_ context.Context, _ *ffcapi.EventListenerRemoveRequest) (*ffcapi.EventListenerRemoveResponse, ffcapi.ErrorReason, error) {
-	return nil, "", nil
+func (c *tezosConnector) EventListenerRemove(ctx context.Context, req *ffcapi.EventListenerRemoveRequest) (*ffcapi.EventListenerRemoveResponse, ffcapi.ErrorReason, error) {
+	c.mux.Lock()
+	es := c.eventStreams[*req.StreamID]
+	if es == nil {
+		c.mux.Unlock()
+		return nil, ffcapi.ErrorReasonNotFound, i18n.NewError(ctx, msgs.MsgStreamNotStarted, req.StreamID)
+	}
+	l := es.removeEventListener(req.ListenerID)
+	c.mux.Unlock()
+	if l == nil {
+		return nil, ffcapi.ErrorReasonNotFound, i18n.NewError(ctx, msgs.MsgListenerNotStarted, req.ListenerID, req.StreamID)
+	}
+	// The catchup loop of the listener (if any) has been cancelled, and exits in the background
+	return &ffcapi.EventListenerRemoveResponse{}, "", nil
 }
 
 // EventListenerHWM queries the current high water mark checkpoint for a listener. Called at regular intervals when there are no events in flight for a listener, to ensure checkpoint are written regularly even when there is no activity
-func (c *tezosConnector) EventListenerHWM(_ context.Context, _ *ffcapi.EventListenerHWMRequest) (*ffcapi.EventListenerHWMResponse, ffcapi.ErrorReason, error) {
-	return nil, "", nil
+func (c *tezosConnector) EventListenerHWM(ctx context.Context, req *ffcapi.EventListenerHWMRequest) (*ffcapi.EventListenerHWMResponse, ffcapi.ErrorReason, error) {
+	es, l, err := c.getStreamListener(ctx, req.StreamID, req.ListenerID)
+	if err != nil {
+		return nil, ffcapi.ErrorReasonNotFound, err
+	}
+	es.mux.Lock()
+	catchup := es.catchup || l.catchup
+	// Blocks at the head of the chain could still be replaced in a re-org, so we hold the checkpoint back
+	firstUnstableBlock := es.headBlock - c.checkpointBlockGap + 1
+	es.mux.Unlock()
+	return &ffcapi.EventListenerHWMResponse{
+		Checkpoint: l.getHWMCheckpoint(firstUnstableBlock),
+		Catchup:    catchup,
+	}, "", nil
 }
 
 // EventStreamNewCheckpointStruct used during checkpoint restore, to get the specific into which to restore the JSON bytes
 func (c *tezosConnector) EventStreamNewCheckpointStruct() ffcapi.EventListenerCheckpoint {
-	return nil
+	return &listenerCheckpoint{}
+}
+
+func (c *tezosConnector) getStreamListener(ctx context.Context, streamID, listenerID *fftypes.UUID) (*eventStream, *listener, error) {
+	c.mux.Lock()
+	es := c.eventStreams[*streamID]
+	c.mux.Unlock()
+	if es == nil {
+		return nil, nil, i18n.NewError(ctx, msgs.MsgStreamNotStarted, streamID)
+	}
+	es.mux.Lock()
+	l := es.listeners[*listenerID]
+	es.mux.Unlock()
+	if l == nil {
+		return nil, nil, i18n.NewError(ctx, msgs.MsgListenerNotStarted, listenerID, streamID)
+	}
+	return es, l, nil
 }
@@ -0,0 +1,266 @@
+package tezos
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/trilitech/tzgo/micheline"
+)
+
+func TestEventListenerVerifyOptionsOK(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	res, reason, err := c.EventListenerVerifyOptions(ctx, &ffcapi.EventListenerVerifyOptionsRequest{
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "0",
+			Filters: []fftypes.JSONAny{
+				*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `","tag":"transfer","type":{"prim":"pair","args":[{"prim":"address"},{"prim":"nat"}]}}`),
+				*fftypes.JSONAnyPtr(`{"address":"KT18g4ExUQ8Ns4yPfg9CGoR66m5wVqSSvhJv"}`),
+				*fftypes.JSONAnyPtr(`{"kind":"storage","address":"` + testEventContract + `"}`),
+				*fftypes.JSONAnyPtr(`{"kind":"bigmap","bigMap":42,"actions":["update","remove"]}`),
+				*fftypes.JSONAnyPtr(`{"kind":"call","address":"` + testEventContract + `","entrypoints":["transfer","mint"]}`),
+				*fftypes.JSONAnyPtr(`{"kind":"balance","addresses":["tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN","` + testEventContract + `"]}`),
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+	assert.Equal(t, testEventContract+":transfer;KT18g4ExUQ8Ns4yPfg9CGoR66m5wVqSSvhJv:*;"+testEventContract+":storage;bigmap:42;"+testEventContract+":transfer,mint;balance:tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN,"+testEventContract, res.ResolvedSignature)
+	assert.JSONEq(t, `{"filters":[`+
+		`{"kind":"event","address":"`+testEventContract+`","tag":"transfer","type":{"prim":"pair","args":[{"prim":"address"},{"prim":"nat"}]}},`+
+		`{"kind":"event","address":"KT18g4ExUQ8Ns4yPfg9CGoR66m5wVqSSvhJv"},`+
+		`{"kind":"storage","address":"`+testEventContract+`"},`+
+		`{"kind":"bigmap","bigMap":42,"actions":["update","remove"]},`+
+		`{"kind":"call","address":"`+testEventContract+`","entrypoints":["transfer","mint"]},`+
+		`{"kind":"balance","addresses":["tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN","`+testEventContract+`"]}`+
+		`]}`, res.ResolvedOptions.String())
+}
+
+func TestEventListenerVerifyOptionsLatestDoesNotWaitForNode(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	// the head of the chain is not needed to verify the options, so the block listener is not started
+	res, _, err := c.EventListenerVerifyOptions(ctx, &ffcapi.EventListenerVerifyOptionsRequest{
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: ffcapi.FromBlockLatest,
+			Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, testEventContract+":*", res.ResolvedSignature)
+	assert.Nil(t, c.blockListener.listenLoopDone)
+}
+
+func TestEventListenerVerifyOptionsKeepsOptions(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	res, _, err := c.EventListenerVerifyOptions(ctx, &ffcapi.EventListenerVerifyOptionsRequest{
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "0",
+			Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)},
+			Options:   fftypes.JSONAnyPtr(`{"custom":true}`),
+		},
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"custom":true,"filters":[{"kind":"event","address":"`+testEventContract+`"}]}`, res.ResolvedOptions.String())
+}
+
+func TestEventListenerVerifyOptionsErrors(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	for filters, errRegexp := range map[string]string{
+		``:                    "FF23035",
+		`[]`:                  "FF23036",
+		`{}`:                  "FF23036",
+		`{"address":"wrong"}`: "FF23036",
+		`{"address":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"}`:                "FF23036.*KT1",
+		`{"address":"` + testEventContract + `","type":{"prim":"unknown"}}`: "FF23036",
+		`{"kind":"unknown","address":"` + testEventContract + `"}`:          "FF23036.*unknown filter kind",
+		`{"kind":"storage"}`: "FF23036",
+		`{"kind":"storage","address":"` + testEventContract + `","tag":"a"}`: "FF23036.*tag and type",
+		`{"address":"` + testEventContract + `","bigMap":1}`:                 "FF23036.*bigMap and actions",
+		`{"kind":"bigmap"}`:                               "FF23036.*bigMap",
+		`{"kind":"bigmap","bigMap":-1}`:                   "FF23036.*bigMap",
+		`{"kind":"bigmap","bigMap":1,"actions":["copy"]}`: "FF23036.*copy",
+		`{"kind":"bigmap","bigMap":1,"address":"wrong"}`:  "FF23036",
+		`{"kind":"call"}`:                                 "FF23036",
+		`{"kind":"call","address":"` + testEventContract + `","entrypoints":[""]}`: "FF23036.*entrypoint",
+		`{"address":"` + testEventContract + `","entrypoints":["transfer"]}`:       "FF23036.*entrypoints only",
+		`{"kind":"balance"}`: "FF23036.*list of addresses",
+		`{"kind":"balance","address":"` + testEventContract + `"}`:                                           "FF23036.*list of addresses",
+		`{"kind":"balance","addresses":["wrong"]}`:                                                           "FF23036",
+		`{"kind":"balance","addresses":["sr1Ghq66tYK9y3r8CC1Tf8i8m5nxh8nTvZEf"]}`:                            "FF23036.*not an account",
+		`{"kind":"storage","address":"` + testEventContract + `","addresses":["` + testEventContract + `"]}`: "FF23036.*addresses only",
+	} {
+		req := &ffcapi.EventListenerVerifyOptionsRequest{}
+		if filters != "" {
+			req.Filters = []fftypes.JSONAny{*fftypes.JSONAnyPtr(filters)}
+		}
+		_, reason, err := c.EventListenerVerifyOptions(ctx, req)
+		assert.Regexp(t, errRegexp, err, filters)
+		assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+	}
+
+	_, _, err := c.EventListenerVerifyOptions(ctx, &ffcapi.EventListenerVerifyOptionsRequest{
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			Filters: []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)},
+			Options: fftypes.JSONAnyPtr(`[]`),
+		},
+	})
+	assert.Regexp(t, "FF23033", err)
+
+	for _, fromBlock := range []string{"abc", "-1"} {
+		_, _, err = c.EventListenerVerifyOptions(ctx, &ffcapi.EventListenerVerifyOptionsRequest{
+			EventListenerOptions: ffcapi.EventListenerOptions{
+				FromBlock: fromBlock,
+				Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)},
+			},
+		})
+		assert.Regexp(t, "FF23034", err, fromBlock)
+	}
+}
+
+func TestEventListenerHWMNotStarted(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	_, reason, err := c.EventListenerHWM(ctx, &ffcapi.EventListenerHWMRequest{
+		StreamID:   fftypes.NewUUID(),
+		ListenerID: fftypes.NewUUID(),
+	})
+	assert.Regexp(t, "FF23041", err)
+	assert.Equal(t, ffcapi.ErrorReasonNotFound, reason)
+
+	streamID := fftypes.NewUUID()
+	c.eventStreams[*streamID] = &eventStream{listeners: map[fftypes.UUID]*listener{}}
+	_, _, err = c.EventListenerHWM(ctx, &ffcapi.EventListenerHWMRequest{
+		StreamID:   streamID,
+		ListenerID: fftypes.NewUUID(),
+	})
+	assert.Regexp(t, "FF23043", err)
+}
+
+func TestEventStreamNewCheckpointStruct(t *testing.T) {
+	_, c, _, done := newTestConnector(t)
+	defer done()
+
+	assert.IsType(t, &listenerCheckpoint{}, c.EventStreamNewCheckpointStruct())
+}
+
+func TestEventListenerAddLive(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(1), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 0).Return(testBlockWithOps(0), nil)
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1, testEventOperation(
+		testEventResult(testEventContract, "transfer", micheline.NewString("hello")),
+	)), nil)
+	mockBlockLevel(mRPC, 2).Return(nil, errors.New("status 404")).Maybe()
+
+	events, cancel, es := startTestStream(t, c)
+
+	req := &ffcapi.EventListenerAddRequest{
+		StreamID:   es.id,
+		ListenerID: fftypes.NewUUID(),
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "0",
+			Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)},
+		},
+	}
+	_, _, err := c.EventListenerAdd(es.ctx, req)
+	assert.NoError(t, err)
+
+	e := <-events
+	assert.Equal(t, req.ListenerID, e.Event.ID.ListenerID)
+	assert.Equal(t, uint64(1), e.Event.ID.BlockNumber.Uint64())
+
+	_, reason, err := c.EventListenerAdd(es.ctx, req)
+	assert.Regexp(t, "FF23038", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+
+	_, _, err = c.EventListenerRemove(es.ctx, &ffcapi.EventListenerRemoveRequest{StreamID: es.id, ListenerID: req.ListenerID})
+	assert.NoError(t, err)
+	assert.Empty(t, es.listeners)
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func TestEventListenerRemoveStopsCatchup(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+	c.catchupPageSize = 1
+	c.catchupThreshold = 2
+	// The catchup loop fails to fetch blocks, and waits a long time before it retries
+	c.retry.InitialDelay = 1 * time.Hour
+	c.retry.MaximumDelay = 1 * time.Hour
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(10), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	getBlockCalled := make(chan struct{}, 1)
+	mRPC.On("GetBlock", mock.Anything, mock.Anything).Return(nil, errors.New("pop")).Run(func(args mock.Arguments) {
+		select {
+		case getBlockCalled <- struct{}{}:
+		default:
+		}
+	}).Maybe()
+
+	_, cancel, es := startTestStream(t, c)
+	for es.getHeadBlock() != 10 {
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	listenerID := fftypes.NewUUID()
+	_, _, err := c.EventListenerAdd(es.ctx, &ffcapi.EventListenerAddRequest{
+		StreamID:   es.id,
+		ListenerID: listenerID,
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "0",
+			Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)},
+		},
+	})
+	assert.NoError(t, err)
+	l := es.listeners[*listenerID]
+	assert.NotNil(t, l.catchupLoopDone)
+	<-getBlockCalled
+
+	// Removing the listener does not wait for the retry, and the catchup loop exits straight away
+	_, _, err = c.EventListenerRemove(es.ctx, &ffcapi.EventListenerRemoveRequest{StreamID: es.id, ListenerID: listenerID})
+	assert.NoError(t, err)
+	<-l.catchupLoopDone
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func TestEventListenerAddRemoveNotStarted(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	_, reason, err := c.EventListenerAdd(ctx, &ffcapi.EventListenerAddRequest{StreamID: fftypes.NewUUID(), ListenerID: fftypes.NewUUID()})
+	assert.Regexp(t, "FF23041", err)
+	assert.Equal(t, ffcapi.ErrorReasonNotFound, reason)
+
+	_, reason, err = c.EventListenerRemove(ctx, &ffcapi.EventListenerRemoveRequest{StreamID: fftypes.NewUUID(), ListenerID: fftypes.NewUUID()})
+	assert.Regexp(t, "FF23041", err)
+	assert.Equal(t, ffcapi.ErrorReasonNotFound, reason)
+
+	streamID := fftypes.NewUUID()
+	c.eventStreams[*streamID] = &eventStream{listeners: map[fftypes.UUID]*listener{}}
+	_, reason, err = c.EventListenerRemove(ctx, &ffcapi.EventListenerRemoveRequest{StreamID: streamID, ListenerID: fftypes.NewUUID()})
+	assert.Regexp(t, "FF23043", err)
+	assert.Equal(t, ffcapi.ErrorReasonNotFound, reason)
+}
@@ -0,0 +1,420 @@
+package tezos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/trilitech/tzgo/micheline"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+// operationResult is a single operation result within an operation group - either one of the contents
+// of the group, or one of the internal results of a content - which is what listeners are matched against
+type operationResult struct {
+	kind        tezos.OpType
+	source      tezos.Address // the sender of a transaction, the emitter of an event, or the originator of a contract
+	origin      tezos.Address // the implicit account that signed the operation content the result belongs to
+	destination tezos.Address // the target of a transaction, or the contract created by an origination
+	amount      int64
+	parameters  *micheline.Parameters
+	entrypoint  string          // the entrypoint of a transaction, which is "default" for a transaction without parameters
+	paramType   *micheline.Prim // the type of the entrypoint, once it has been resolved against the contract's script
+	result      rpc.OperationResult
+	fees        rpc.BalanceUpdates  // only set for contents, which pay the fees of the operation
+	internal    *rpc.InternalResult // only set for internal results
+	bigMapDiffs micheline.BigmapEvents
+	// whether a call changed the storage of the contract, once it has been compared with the storage before the call
+	storageChanged  bool
+	storageResolved bool
+}
+
+const (
+	// balanceReasonFee is the payment of the fee of an operation to the baker
+	balanceReasonFee = "fee"
+	// balanceReasonTransfer is tez moving between two accounts
+	balanceReasonTransfer = "transfer"
+	// balanceReasonBurn is tez destroyed, such as to pay for storage or allocate a new account
+	balanceReasonBurn = "burn"
+)
+
+// balanceChange is the JSON representation of a change to the tez balance of an account. The reason is
+// a fee, transfer or burn, or otherwise the kind of the balance the tez moved to or from (such as a deposit).
+type balanceChange struct {
+	Address      string `json:"address"`
+	Change       string `json:"change"`
+	Reason       string `json:"reason"`
+	Category     string `json:"category,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"`
+}
+
+// callData is the JSON representation of a call to the entrypoint of a contract
+type callData struct {
+	Sender     string      `json:"sender"`
+	Source     string      `json:"source"`
+	Amount     string      `json:"amount"`
+	Entrypoint string      `json:"entrypoint"`
+	Parameters interface{} `json:"parameters,omitempty"`
+}
+
+// bigMapDiff is the JSON representation of a change to a big_map
+type bigMapDiff struct {
+	BigMap    int64           `json:"bigMap"`
+	Action    string          `json:"action"`
+	KeyHash   string          `json:"keyHash,omitempty"`
+	Key       interface{}     `json:"key,omitempty"`
+	Value     interface{}     `json:"value,omitempty"`
+	KeyType   *micheline.Prim `json:"keyType,omitempty"`
+	ValueType *micheline.Prim `json:"valueType,omitempty"`
+}
+
+type bigMapTypes struct {
+	key   micheline.Prim
+	value micheline.Prim
+}
+
+func newContentResult(content rpc.TypedOperation) *operationResult {
+	r := &operationResult{
+		kind:   content.Kind(),
+		result: content.Meta().Result,
+		fees:   content.Meta().BalanceUpdates,
+	}
+	switch op := content.(type) {
+	case *rpc.Transaction:
+		r.source = op.Source
+		r.destination = op.Destination
+		r.amount = op.Amount
+		r.parameters = op.Parameters
+	case *rpc.Origination:
+		r.source = op.Source
+	}
+	r.origin = r.source
+	r.init()
+	return r
+}
+
+func newInternalResult(content *operationResult, ir *rpc.InternalResult) *operationResult {
+	r := &operationResult{
+		kind:       ir.Kind,
+		source:     ir.Source,
+		origin:     content.origin,
+		amount:     ir.Amount,
+		parameters: ir.Parameters,
+		result:     ir.Result,
+		internal:   ir,
+	}
+	if ir.Destination != nil {
+		r.destination = *ir.Destination
+	}
+	r.init()
+	return r
+}
+
+func (r *operationResult) init() {
+	if r.kind == tezos.OpTypeOrigination && len(r.result.OriginatedContracts) > 0 {
+		r.destination = r.result.OriginatedContracts[0]
+	}
+	if r.kind == tezos.OpTypeTransaction {
+		r.entrypoint = micheline.DEFAULT
+		if r.parameters != nil && r.parameters.Entrypoint != "" {
+			r.entrypoint = r.parameters.Entrypoint
+		}
+	}
+	if r.result.LazyStorageDiff != nil || r.result.BigmapDiff != nil {
+		// The lazy storage diff parser relies on the position of the "kind" field, so needs compact JSON
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, r.result.LazyStorageDiff); err == nil {
+			r.result.LazyStorageDiff = compacted.Bytes()
+		}
+		r.bigMapDiffs = r.result.BigmapEvents()
+	}
+}
+
+// contractAddress is the contract the result relates to - the emitter of an event, or the contract
+// that was called or created
+func (r *operationResult) contractAddress() string {
+	if r.kind == tezos.OpTypeEvent {
+		return r.source.String()
+	}
+	return r.destination.String()
+}
+
+// balanceUpdates are the changes to the tez balances of accounts made by the result. The fees are paid
+// whether or not the operation succeeds, but the other changes only happen if it succeeds.
+func (r *operationResult) balanceUpdates() []*balanceChange {
+	changes := buildBalanceChanges(r.fees, balanceReasonFee)
+	if r.result.IsSuccess() {
+		changes = append(changes, buildBalanceChanges(r.result.BalanceUpdates, "")...)
+	}
+	return changes
+}
+
+// buildBalanceChanges converts the balance updates of accounts into changes, using the other side of each
+// update to work out why the balance changed. The updates come in pairs, where one is the debit and the
+// other is the matching credit.
+func buildBalanceChanges(updates rpc.BalanceUpdates, reason string) []*balanceChange {
+	changes := make([]*balanceChange, 0, len(updates))
+	for i, u := range updates {
+		if u.Kind != rpc.CONTRACT {
+			continue
+		}
+		c := &balanceChange{
+			Address: u.Contract.String(),
+			Change:  strconv.FormatInt(u.Change, 10),
+			Reason:  balanceReasonTransfer,
+		}
+		if other := i ^ 1; other < len(updates) && updates[other].Change == -u.Change {
+			switch o := updates[other]; o.Kind {
+			case rpc.CONTRACT:
+				c.Counterparty = o.Contract.String()
+			case "burned":
+				c.Reason = balanceReasonBurn
+				c.Category = o.Category
+			default:
+				c.Reason = o.Kind
+				c.Category = o.Category
+			}
+		}
+		if reason != "" {
+			c.Reason = reason
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// buildEventData builds the JSON data of the event delivered for an operation result that matched a filter
+func (es *eventStream) buildEventData(ctx context.Context, block *rpc.Block, f *eventFilter, r *operationResult) ([]byte, error) {
+	var data interface{}
+	switch f.Kind {
+	case filterKindStorage:
+		storageType, err := es.getStorageType(ctx, r.destination)
+		if err != nil {
+			return nil, err
+		}
+		data = map[string]interface{}{
+			"storage": es.formatValue(ctx, storageType, *r.result.Storage),
+		}
+	case filterKindBigMap:
+		diffs, err := es.c.buildBigMapDiffs(ctx, r.bigMapDiffs, f.matchesBigMapDiff, func(ctx context.Context, id int64) (*bigMapTypes, error) {
+			return es.getBigMapTypes(ctx, id, block.GetLevel())
+		})
+		if err != nil {
+			return nil, err
+		}
+		data = map[string]interface{}{
+			"diffs": diffs,
+		}
+	case filterKindCall:
+		call, err := es.buildCallData(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		data = call
+	case filterKindBalance:
+		changes := make([]*balanceChange, 0)
+		for _, c := range r.balanceUpdates() {
+			if f.matchesAddress(c.Address) {
+				changes = append(changes, c)
+			}
+		}
+		data = map[string]interface{}{
+			"changes": changes,
+		}
+	default:
+		data = es.formatValue(ctx, r.internal.Type, r.internal.Payload)
+	}
+	return json.Marshal(data)
+}
+
+func (es *eventStream) buildCallData(ctx context.Context, r *operationResult) (*callData, error) {
+	if err := es.resolveEntrypoint(ctx, r); err != nil {
+		return nil, err
+	}
+	call := &callData{
+		Sender:     r.source.String(),
+		Source:     r.origin.String(),
+		Amount:     strconv.FormatInt(r.amount, 10),
+		Entrypoint: r.entrypoint,
+	}
+	if r.parameters != nil {
+		if r.paramType != nil {
+			call.Parameters = es.formatValue(ctx, *r.paramType, r.parameters.Value)
+		} else {
+			call.Parameters = r.parameters.Value
+		}
+	}
+	return call, nil
+}
+
+// resolveEntrypoint works out which entrypoint of the contract a transaction called, along with its type.
+// A transaction to the "default" entrypoint of a contract that does not declare one actually calls one of
+// the entrypoints, selected by the Left/Right branches that wrap the value.
+func (es *eventStream) resolveEntrypoint(ctx context.Context, r *operationResult) error {
+	if r.paramType != nil || r.parameters == nil {
+		return nil
+	}
+	paramType, err := es.getParameterType(ctx, r.destination)
+	if err != nil {
+		return err
+	}
+	ep, value, err := r.parameters.MapEntrypoint(micheline.NewType(paramType))
+	if err != nil || ep.Prim == nil {
+		log.L(ctx).Warnf("Failed to resolve entrypoint '%s' of %s: %v", r.parameters.Entrypoint, r.destination, err)
+		return nil
+	}
+	// The entrypoint name is annotated on the type, but the value is delivered without it
+	typ := ep.Prim.Clone()
+	typ.Anno = nil
+	r.entrypoint = ep.Name
+	r.parameters = &micheline.Parameters{Entrypoint: ep.Name, Value: value}
+	r.paramType = &typ
+	return nil
+}
+
+// resolveStorageChange compares the storage of a contract after a call with its storage before the call. That is the
+// storage left by an earlier call in the same block, if there was one, or otherwise the storage of the contract at
+// the end of the previous block. A contract that did not exist before the block always has a change of storage.
+func (es *eventStream) resolveStorageChange(ctx context.Context, r *operationResult, blockNumber int64, blockStorage map[string]micheline.Prim) error {
+	previous, ok := blockStorage[r.destination.String()]
+	if !ok {
+		var err error
+		previous, err = es.c.client.GetContractStorage(ctx, r.destination, rpc.BlockLevel(blockNumber-1))
+		if err != nil && mapError(blockRPCMethods, err) != ffcapi.ErrorReasonNotFound {
+			return err
+		}
+	}
+	r.storageChanged = !previous.IsValid() || !previous.IsEqual(*r.result.Storage)
+	r.storageResolved = true
+	return nil
+}
+
+// buildBigMapDiffs decodes the changes to big_maps made by an operation result, for both events and receipts.
+// Only the changes that match are included, or all of them if match is nil. A big_map allocated in the same result
+// (including a temporary one) has its types in the diff, and the types of any other big_map are looked up, but
+// only if the change has a key or value to decode. If the lookup returns no types, the key and value are left as
+// Micheline.
+func (c *tezosConnector) buildBigMapDiffs(ctx context.Context, events micheline.BigmapEvents, match func(micheline.BigmapEvent) bool, getTypes func(ctx context.Context, id int64) (*bigMapTypes, error)) ([]*bigMapDiff, error) {
+	allocated := make(map[int64]*bigMapTypes)
+	diffs := make([]*bigMapDiff, 0, len(events))
+	for _, d := range events {
+		if d.Action == micheline.DiffActionAlloc {
+			allocated[d.Id] = &bigMapTypes{key: d.KeyType, value: d.ValueType}
+		}
+		if match != nil && !match(d) {
+			continue
+		}
+		diff := &bigMapDiff{
+			BigMap: d.Id,
+			Action: d.Action.String(),
+		}
+		if d.Action == micheline.DiffActionAlloc {
+			keyType, valueType := d.KeyType, d.ValueType
+			diff.KeyType = &keyType
+			diff.ValueType = &valueType
+			diffs = append(diffs, diff)
+			continue
+		}
+		if d.Key.IsValid() || d.Value.IsValid() {
+			types := allocated[d.Id]
+			if types == nil {
+				var err error
+				if types, err = getTypes(ctx, d.Id); err != nil {
+					return nil, err
+				}
+			}
+			if d.Key.IsValid() {
+				diff.KeyHash = d.KeyHash.String()
+				diff.Key = d.Key
+				if types != nil {
+					diff.Key = c.formatValue(ctx, types.key, d.Key)
+				}
+			}
+			if d.Value.IsValid() {
+				diff.Value = d.Value
+				if types != nil {
+					diff.Value = c.formatValue(ctx, types.value, d.Value)
+				}
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+func (es *eventStream) formatValue(ctx context.Context, typ, value micheline.Prim) interface{} {
+	return es.c.formatValue(ctx, typ, value)
+}
+
+// formatValue decodes a Micheline value into the configured data format. If the value does not match the type,
+// the raw Micheline is returned, so that the event or receipt is still delivered.
+func (c *tezosConnector) formatValue(ctx context.Context, typ, value micheline.Prim) interface{} {
+	decoded, err := c.dataFormat.formatValue(typ, value)
+	if err != nil {
+		log.L(ctx).Warnf("Failed to decode value using type %s: %s", typ.Dump(), err)
+		return value
+	}
+	return decoded
+}
+
+// getStorageType gets the storage type of a contract, which never changes after origination so is cached
+func (es *eventStream) getStorageType(ctx context.Context, address tezos.Address) (micheline.Prim, error) {
+	return es.getScriptType(ctx, "storage:"+address.String(), address)
+}
+
+// getParameterType gets the parameter type of a contract, which never changes after origination so is cached
+func (es *eventStream) getParameterType(ctx context.Context, address tezos.Address) (micheline.Prim, error) {
+	return es.getScriptType(ctx, "parameter:"+address.String(), address)
+}
+
+// getScriptType fetches the script of a contract on a cache miss, and caches both its storage and parameter types
+func (es *eventStream) getScriptType(ctx context.Context, key string, address tezos.Address) (micheline.Prim, error) {
+	if typ, ok := es.getCachedType(key); ok {
+		return typ, nil
+	}
+	script, err := es.c.client.GetContractScript(ctx, address)
+	if err != nil {
+		return micheline.InvalidPrim, err
+	}
+	es.setCachedType("storage:"+address.String(), script.StorageType().Prim)
+	es.setCachedType("parameter:"+address.String(), script.ParamType().Prim)
+	typ, _ := es.getCachedType(key)
+	return typ, nil
+}
+
+// getBigMapTypes gets the key and value types of a big_map, which never change after allocation so are cached
+func (es *eventStream) getBigMapTypes(ctx context.Context, id, blockNumber int64) (*bigMapTypes, error) {
+	prefix := "bigmap:" + strconv.FormatInt(id, 10)
+	keyType, keyOK := es.getCachedType(prefix + ":key")
+	valueType, valueOK := es.getCachedType(prefix + ":value")
+	if keyOK && valueOK {
+		return &bigMapTypes{key: keyType, value: valueType}, nil
+	}
+	info, err := es.c.client.GetBigmapInfo(ctx, id, rpc.BlockLevel(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+	es.setCachedType(prefix+":key", info.KeyType)
+	es.setCachedType(prefix+":value", info.ValueType)
+	return &bigMapTypes{key: info.KeyType, value: info.ValueType}, nil
+}
+
+func (es *eventStream) getCachedType(key string) (micheline.Prim, bool) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	typ, ok := es.types[key]
+	return typ, ok
+}
+
+func (es *eventStream) setCachedType(key string, typ micheline.Prim) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	if es.types == nil {
+		es.types = make(map[string]micheline.Prim)
+	}
+	es.types[key] = typ
+}
@@ -1,7 +1,384 @@
 package tezos
 
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/trilitech/tzgo/micheline"
+	"github.com/trilitech/tzgo/tezos"
+)
+
 // listener is the state we hold in memory for each individual listener that has been added
 type listener struct {
+	id              *fftypes.UUID
+	c               *tezosConnector
+	es              *eventStream
+	hwmMux          sync.Mutex // Protects checkpoint of an individual listener. May hold ES lock when taking this, must NOT attempt to obtain ES lock while holding this
 	hwmBlock        int64
+	startBlock      int64               // the block the listener started (or resumed) from, which the checkpoint is never held back behind
+	lastCheckpoint  *listenerCheckpoint // the position of the last event delivered (or restored) for this listener
+	config          listenerConfig
+	catchup         bool
 	catchupLoopDone chan struct{}
+	cancelCatchup   context.CancelFunc // cancels the catchup loop (if any) when the listener is removed, protected by the ES lock
+	removed         bool               // protected by the ES lock
+}
+
+type listenerConfig struct {
+	name      string
+	fromBlock string
+	filters   []*eventFilter
+	signature string
+	options   map[string]interface{} // the options supplied for the listener, which the connector has no settings in
+}
+
+const (
+	// filterKindEvent matches events emitted by a contract with the Michelson EMIT instruction
+	filterKindEvent = "event"
+	// filterKindStorage matches the successful calls to a contract that change its storage
+	filterKindStorage = "storage"
+	// filterKindBigMap matches changes to the entries of a big_map
+	filterKindBigMap = "bigmap"
+	// filterKindCall matches transactions that call a contract, including internal transactions from other contracts
+	filterKindCall = "call"
+	// filterKindBalance matches every movement of tez in or out of a set of accounts, including fees and burns
+	filterKindBalance = "balance"
+)
+
+// eventFilter selects the operation results a listener is interested in. The filters of a listener
+// are a JSON array, and an event is delivered if it matches any one of the filters:
+//
+//	[{"address": "KT1...", "tag": "transfer", "type": {"prim": "pair", "args": [{"prim": "address"}, {"prim": "nat"}]}}]
+//
+// The kind of the filter defaults to "event", for events emitted by a contract. The address of the contract that
+// emitted the event is required. The tag (as supplied to the EMIT instruction) and the Michelson type of the payload
+// are optional, and match everything when omitted. Annotations are ignored when matching the type.
+//
+// A "storage" filter requires the address of a contract, and matches the calls that change its storage.
+// A "bigmap" filter requires the bigMap id, along with an optional address of the contract making the change and
+// the optional list of actions (update, remove, alloc).
+// A "call" filter requires the address of the contract being called, and optionally the list of entrypoints.
+// A "balance" filter requires the list of addresses (tz1, tz2, tz3 or KT1) whose tez balances are followed.
+// When a single operation result matches multiple filters of a listener, the first filter determines the event.
+type eventFilter struct {
+	Kind        string          `json:"kind,omitempty"`
+	Address     string          `json:"address,omitempty"`
+	Tag         string          `json:"tag,omitempty"`
+	Type        *micheline.Prim `json:"type,omitempty"`
+	BigMap      *int64          `json:"bigMap,omitempty"`
+	Actions     []string        `json:"actions,omitempty"`
+	Entrypoints []string        `json:"entrypoints,omitempty"`
+	Addresses   []string        `json:"addresses,omitempty"`
+}
+
+// signature is a human readable summary of the filter, that FFTM uses as the default name of a listener
+func (f *eventFilter) signature() string {
+	switch f.Kind {
+	case filterKindStorage:
+		return f.Address + ":storage"
+	case filterKindBigMap:
+		return "bigmap:" + strconv.FormatInt(*f.BigMap, 10)
+	case filterKindCall:
+		if len(f.Entrypoints) == 0 {
+			return f.Address + ":*"
+		}
+		return f.Address + ":" + strings.Join(f.Entrypoints, ",")
+	case filterKindBalance:
+		return "balance:" + strings.Join(f.Addresses, ",")
+	default:
+		tag := f.Tag
+		if tag == "" {
+			tag = "*"
+		}
+		return f.Address + ":" + tag
+	}
+}
+
+// matches checks whether an operation result passes the filter. Only successful results match, apart from
+// balance filters, as the fees of an operation are paid even if it fails.
+func (f *eventFilter) matches(r *operationResult) bool {
+	if f.Kind == filterKindBalance {
+		for _, u := range r.balanceUpdates() {
+			if f.matchesAddress(u.Address) {
+				return true
+			}
+		}
+		return false
+	}
+	if !r.result.IsSuccess() {
+		return false
+	}
+	switch f.Kind {
+	case filterKindStorage:
+		return r.storageChanged && r.destination.String() == f.Address
+	case filterKindBigMap:
+		if f.Address != "" && r.destination.String() != f.Address {
+			return false
+		}
+		for _, d := range r.bigMapDiffs {
+			if f.matchesBigMapDiff(d) {
+				return true
+			}
+		}
+		return false
+	case filterKindCall:
+		if r.kind != tezos.OpTypeTransaction || r.destination.String() != f.Address {
+			return false
+		}
+		if len(f.Entrypoints) == 0 {
+			return true
+		}
+		for _, ep := range f.Entrypoints {
+			if ep == r.entrypoint {
+				return true
+			}
+		}
+		return false
+	default:
+		if r.kind != tezos.OpTypeEvent || r.source.String() != f.Address {
+			return false
+		}
+		if f.Tag != "" && f.Tag != r.internal.Tag {
+			return false
+		}
+		return f.Type == nil || f.Type.IsEqual(r.internal.Type)
+	}
+}
+
+func (f *eventFilter) matchesAddress(address string) bool {
+	for _, a := range f.Addresses {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *eventFilter) matchesBigMapDiff(d micheline.BigmapEvent) bool {
+	if d.Id != *f.BigMap {
+		return false
+	}
+	action := d.Action.String()
+	if len(f.Actions) == 0 {
+		return bigMapActions[action]
+	}
+	for _, a := range f.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// bigMapActions are the changes to a big_map that can be delivered
+var bigMapActions = map[string]bool{
+	micheline.DiffActionUpdate.String(): true,
+	micheline.DiffActionRemove.String(): true,
+	micheline.DiffActionAlloc.String():  true,
+}
+
+// resolvedOptions returns the options of the listener for FFTM to persist, with the filters as they were resolved
+// (with the default kind set, and addresses in their canonical form) alongside any other options supplied
+func (l *listener) resolvedOptions() *fftypes.JSONAny {
+	options := make(map[string]interface{}, len(l.config.options)+1)
+	for k, v := range l.config.options {
+		options[k] = v
+	}
+	options["filters"] = l.config.filters
+	b, _ := json.Marshal(options)
+	return fftypes.JSONAnyPtrBytes(b)
+}
+
+// needsEntrypoint checks whether the entrypoint of a transaction needs resolving against the script of the contract,
+// before it can be matched against the entrypoints of a call filter. Only a call to the default entrypoint can
+// actually be a call to one of the other entrypoints.
+func (l *listener) needsEntrypoint(r *operationResult) bool {
+	if r.entrypoint != micheline.DEFAULT || r.parameters == nil || r.paramType != nil || !r.result.IsSuccess() {
+		return false
+	}
+	for _, f := range l.config.filters {
+		if f.Kind == filterKindCall && len(f.Entrypoints) > 0 && r.destination.String() == f.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// needsStorageChange checks whether the storage of a contract after a call needs comparing with its storage before
+// the call, before it can be matched against a storage filter
+func (l *listener) needsStorageChange(r *operationResult) bool {
+	if r.result.Storage == nil || r.storageResolved || !r.result.IsSuccess() {
+		return false
+	}
+	for _, f := range l.config.filters {
+		if f.Kind == filterKindStorage && r.destination.String() == f.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// listenerCheckpoint is the position in the chain of the last event delivered for a listener. Events are
+// ordered by block level, then validation pass, then the index of the operation group within that pass,
+// then the index of the operation result within the group (counting each content, followed by each of its
+// internal results). A value of -1 for the position fields means no event has been delivered in the block.
+type listenerCheckpoint struct {
+	Block          int64  `json:"block"`
+	BlockHash      string `json:"blockHash,omitempty"`
+	OperationPass  int64  `json:"operationPass"`
+	OperationIndex int64  `json:"operationIndex"`
+	ResultIndex    int64  `json:"resultIndex"`
+}
+
+func (cp *listenerCheckpoint) LessThan(b ffcapi.EventListenerCheckpoint) bool {
+	bcp := b.(*listenerCheckpoint)
+	return cp.Block < bcp.Block ||
+		(cp.Block == bcp.Block &&
+			(cp.OperationPass < bcp.OperationPass ||
+				(cp.OperationPass == bcp.OperationPass &&
+					(cp.OperationIndex < bcp.OperationIndex ||
+						(cp.OperationIndex == bcp.OperationIndex && cp.ResultIndex < bcp.ResultIndex)))))
+}
+
+func (l *listener) getHWM() int64 {
+	l.hwmMux.Lock()
+	defer l.hwmMux.Unlock()
+	return l.hwmBlock
+}
+
+// getHWMCheckpoint returns the checkpoint to restart from, which is the start of the next block to be scanned.
+// That is held back to the first block that is not yet stable (could still be replaced in a re-org), so that
+// the unstable blocks are scanned again on restart. However, if an event has been delivered in (or after) that
+// block then FFTM has already confirmed it, so we restart just after that event.
+func (l *listener) getHWMCheckpoint(firstUnstableBlock int64) *listenerCheckpoint {
+	l.hwmMux.Lock()
+	defer l.hwmMux.Unlock()
+	cp := &listenerCheckpoint{
+		Block:          l.hwmBlock,
+		OperationPass:  -1,
+		OperationIndex: -1,
+		ResultIndex:    -1,
+	}
+	if firstUnstableBlock < cp.Block {
+		cp.Block = firstUnstableBlock
+		if cp.Block < l.startBlock {
+			cp.Block = l.startBlock
+		}
+	}
+	if l.lastCheckpoint != nil && cp.LessThan(l.lastCheckpoint) {
+		return l.lastCheckpoint
+	}
+	return cp
+}
+
+// isPending checks whether an event at the supplied position is still to be delivered to this listener
+func (l *listener) isPending(cp *listenerCheckpoint) bool {
+	l.hwmMux.Lock()
+	defer l.hwmMux.Unlock()
+	return cp.Block >= l.hwmBlock && (l.lastCheckpoint == nil || l.lastCheckpoint.LessThan(cp))
+}
+
+// markDelivered records the position of an event that has been dispatched for this listener
+func (l *listener) markDelivered(cp *listenerCheckpoint) {
+	l.hwmMux.Lock()
+	defer l.hwmMux.Unlock()
+	l.lastCheckpoint = cp
+}
+
+// moveHWM moves the high water mark forwards (never backwards) to the next block to be scanned
+func (l *listener) moveHWM(hwmBlock int64) {
+	l.hwmMux.Lock()
+	defer l.hwmMux.Unlock()
+	if hwmBlock > l.hwmBlock {
+		l.hwmBlock = hwmBlock
+	}
+}
+
+// rewind moves the listener back to rescan from the supplied block (but never before the block it started from),
+// after the blocks from that point onwards have been replaced in a re-org
+func (l *listener) rewind(block int64) {
+	l.hwmMux.Lock()
+	defer l.hwmMux.Unlock()
+	if block < l.startBlock {
+		block = l.startBlock
+	}
+	if l.hwmBlock > block {
+		l.hwmBlock = block
+	}
+	if l.lastCheckpoint != nil && l.lastCheckpoint.Block >= block {
+		l.lastCheckpoint = nil
+	}
+}
+
+// matches checks whether an operation result passes any of the filters of this listener.
+// The filters are an OR list, so the first matching filter is returned.
+func (l *listener) matches(r *operationResult) *eventFilter {
+	for _, f := range l.config.filters {
+		if f.matches(r) {
+			return f
+		}
+	}
+	return nil
+}
+
+// listenerCatchupLoop pages through the blocks behind the lead group for a single listener, until it is close
+// enough to the head of the stream to rejoin the lead group. The context is cancelled when the listener is removed,
+// so the loop exits straight away, even while it is fetching blocks or waiting to retry.
+func (l *listener) listenerCatchupLoop(ctx context.Context, cancel context.CancelFunc) {
+	defer close(l.catchupLoopDone)
+	defer cancel()
+
+	failCount := 0
+	for {
+		if failCount > 0 {
+			if l.c.doFailureDelay(ctx, failCount) {
+				log.L(ctx).Debugf("Listener %s catchup loop exiting", l.id)
+				return
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				log.L(ctx).Debugf("Listener %s catchup loop exiting", l.id)
+				return
+			default:
+			}
+		}
+
+		l.es.mux.Lock()
+		removed := l.removed
+		l.es.mux.Unlock()
+		if removed {
+			log.L(ctx).Infof("Listener %s removed, catchup loop exiting", l.id)
+			return
+		}
+
+		fromBlock := l.getHWM()
+		headBlock := l.es.getHeadBlock()
+
+		// Check if we're ready to rejoin the lead group
+		if headBlock-fromBlock < l.c.catchupThreshold {
+			log.L(ctx).Infof("Listener %s catchup loop complete at block %d (stream head block: %d), rejoining lead group", l.id, fromBlock, headBlock)
+			l.es.mux.Lock()
+			l.catchup = false
+			l.es.mux.Unlock()
+			return
+		}
+
+		toBlock := fromBlock + l.c.catchupPageSize - 1
+		log.L(ctx).Infof("Listener %s catchup processing blocks %d-%d (stream head block: %d)", l.id, fromBlock, toBlock, headBlock)
+		if stopped, err := l.es.processBlockRange(ctx, []*listener{l}, fromBlock, toBlock); err != nil {
+			log.L(ctx).Errorf("Listener %s failed to process blocks %d-%d in catchup mode: %s", l.id, fromBlock, toBlock, err)
+			failCount++
+			continue
+		} else if stopped {
+			return
+		}
+		failCount = 0
+	}
 }
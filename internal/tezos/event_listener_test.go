@@ -0,0 +1,251 @@
+package tezos
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/trilitech/tzgo/micheline"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+func testEventOpResult(source, tag string, payloadType micheline.Prim) *operationResult {
+	ir := testEventResult(source, tag, micheline.NewString("payload"))
+	ir.Type = payloadType
+	return newInternalResult(&operationResult{}, ir)
+}
+
+func TestListenerMatches(t *testing.T) {
+	natType := micheline.NewCode(micheline.T_NAT)
+	l := &listener{
+		config: listenerConfig{
+			filters: []*eventFilter{
+				{Kind: filterKindEvent, Address: testEventContract, Tag: "transfer", Type: &natType},
+				{Kind: filterKindEvent, Address: "KT18g4ExUQ8Ns4yPfg9CGoR66m5wVqSSvhJv"},
+			},
+		},
+	}
+
+	assert.Equal(t, l.config.filters[0], l.matches(testEventOpResult(testEventContract, "transfer", micheline.NewCodeAnno(micheline.T_NAT, "%amount"))))
+	assert.Nil(t, l.matches(testEventOpResult(testEventContract, "transfer", micheline.NewCode(micheline.T_STRING))))
+	assert.Nil(t, l.matches(testEventOpResult(testEventContract, "other", natType)))
+	assert.Equal(t, l.config.filters[1], l.matches(testEventOpResult("KT18g4ExUQ8Ns4yPfg9CGoR66m5wVqSSvhJv", "anything", micheline.NewCode(micheline.T_UNIT))))
+}
+
+func TestListenerMatchesStorageAndBigMaps(t *testing.T) {
+	bigMap := int64(42)
+	l := &listener{
+		config: listenerConfig{
+			filters: []*eventFilter{
+				{Kind: filterKindStorage, Address: testEventContract},
+				{Kind: filterKindBigMap, BigMap: &bigMap, Actions: []string{"remove"}},
+			},
+		},
+	}
+	storage := micheline.NewNat(big.NewInt(1))
+	call := &operationResult{
+		kind:        tezos.OpTypeTransaction,
+		destination: tezos.MustParseAddress(testEventContract),
+		result:      rpc.OperationResult{Status: tezos.OpStatusApplied, Storage: &storage},
+	}
+	assert.True(t, l.needsStorageChange(call))
+	// a call that leaves the storage unchanged does not match
+	call.storageResolved = true
+	assert.False(t, l.needsStorageChange(call))
+	assert.Nil(t, l.matches(call))
+	call.storageChanged = true
+	assert.Equal(t, l.config.filters[0], l.matches(call))
+	assert.Equal(t, l.config.filters[1], l.matches(&operationResult{
+		kind:        tezos.OpTypeTransaction,
+		destination: tezos.MustParseAddress("KT18g4ExUQ8Ns4yPfg9CGoR66m5wVqSSvhJv"),
+		result:      rpc.OperationResult{Status: tezos.OpStatusApplied, Storage: &storage},
+		bigMapDiffs: micheline.BigmapEvents{{Action: micheline.DiffActionRemove, Id: 42}},
+	}))
+	assert.Nil(t, l.matches(&operationResult{
+		kind:        tezos.OpTypeTransaction,
+		destination: tezos.MustParseAddress("KT18g4ExUQ8Ns4yPfg9CGoR66m5wVqSSvhJv"),
+		result:      rpc.OperationResult{Status: tezos.OpStatusApplied},
+		bigMapDiffs: micheline.BigmapEvents{{Action: micheline.DiffActionUpdate, Id: 42}, {Action: micheline.DiffActionRemove, Id: 43}},
+	}))
+}
+
+func TestListenerMatchesCalls(t *testing.T) {
+	l := &listener{
+		config: listenerConfig{
+			filters: []*eventFilter{
+				{Kind: filterKindCall, Address: testEventContract, Entrypoints: []string{"transfer"}},
+			},
+		},
+	}
+	call := func(params *micheline.Parameters) *operationResult {
+		r := &operationResult{
+			kind:        tezos.OpTypeTransaction,
+			destination: tezos.MustParseAddress(testEventContract),
+			parameters:  params,
+			result:      rpc.OperationResult{Status: tezos.OpStatusApplied},
+		}
+		r.init()
+		return r
+	}
+
+	named := call(&micheline.Parameters{Entrypoint: "transfer", Value: micheline.NewNat(big.NewInt(1))})
+	assert.False(t, l.needsEntrypoint(named))
+	assert.Equal(t, l.config.filters[0], l.matches(named))
+	assert.Nil(t, l.matches(call(nil)))
+
+	// A call to the default entrypoint must be resolved against the contract's script before matching
+	defaulted := call(&micheline.Parameters{Entrypoint: micheline.DEFAULT, Value: micheline.NewCode(micheline.D_LEFT, micheline.NewNat(big.NewInt(1)))})
+	assert.True(t, l.needsEntrypoint(defaulted))
+	assert.Nil(t, l.matches(defaulted))
+	assert.False(t, l.needsEntrypoint(call(nil)))
+}
+
+func TestBuildBalanceChanges(t *testing.T) {
+	account := tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
+	contract := tezos.MustParseAddress(testEventContract)
+	changes := buildBalanceChanges(rpc.BalanceUpdates{
+		{Kind: rpc.CONTRACT, Contract: account, Change: -100},
+		{Kind: rpc.CONTRACT, Contract: contract, Change: 100},
+		{Kind: rpc.CONTRACT, Contract: account, Change: -250},
+		{Kind: "burned", Category: "storage fees", Change: 250},
+		{Kind: "freezer", Category: "deposits", Change: 30},
+		{Kind: rpc.CONTRACT, Contract: account, Change: -30},
+		{Kind: rpc.CONTRACT, Contract: contract, Change: 7},
+	}, "")
+	assert.Equal(t, []*balanceChange{
+		{Address: account.String(), Change: "-100", Reason: balanceReasonTransfer, Counterparty: contract.String()},
+		{Address: contract.String(), Change: "100", Reason: balanceReasonTransfer, Counterparty: account.String()},
+		{Address: account.String(), Change: "-250", Reason: balanceReasonBurn, Category: "storage fees"},
+		{Address: account.String(), Change: "-30", Reason: "freezer", Category: "deposits"},
+		{Address: contract.String(), Change: "7", Reason: balanceReasonTransfer},
+	}, changes)
+
+	fees := buildBalanceChanges(rpc.BalanceUpdates{
+		{Kind: rpc.CONTRACT, Contract: account, Change: -1000},
+		{Kind: "accumulator", Category: "block fees", Change: 1000},
+	}, balanceReasonFee)
+	assert.Equal(t, []*balanceChange{
+		{Address: account.String(), Change: "-1000", Reason: balanceReasonFee, Category: "block fees"},
+	}, fees)
+}
+
+func TestListenerMatchesBalanceOfFailedOperation(t *testing.T) {
+	account := tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
+	l := &listener{
+		config: listenerConfig{
+			filters: []*eventFilter{
+				{Kind: filterKindCall, Address: testEventContract},
+				{Kind: filterKindBalance, Addresses: []string{account.String()}},
+			},
+		},
+	}
+	r := &operationResult{
+		kind:        tezos.OpTypeTransaction,
+		destination: tezos.MustParseAddress(testEventContract),
+		result: rpc.OperationResult{
+			Status:         tezos.OpStatusBacktracked,
+			BalanceUpdates: rpc.BalanceUpdates{{Kind: rpc.CONTRACT, Contract: account, Change: -5}},
+		},
+		fees: rpc.BalanceUpdates{{Kind: rpc.CONTRACT, Contract: account, Change: -1000}},
+	}
+	// The fees are paid even though the operation failed, but the failed transfer did not happen
+	assert.Equal(t, l.config.filters[1], l.matches(r))
+	assert.Len(t, r.balanceUpdates(), 1)
+	r.fees = nil
+	assert.Nil(t, l.matches(r))
+}
+
+func TestListenerCheckpointRoundTrip(t *testing.T) {
+	cp := &listenerCheckpoint{
+		Block:          12345,
+		BlockHash:      "BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg",
+		OperationPass:  3,
+		OperationIndex: 2,
+		ResultIndex:    1,
+	}
+	b, err := json.Marshal(cp)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"block":12345,"blockHash":"BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg","operationPass":3,"operationIndex":2,"resultIndex":1}`, string(b))
+
+	var restored listenerCheckpoint
+	err = json.Unmarshal(b, &restored)
+	assert.NoError(t, err)
+	assert.Equal(t, *cp, restored)
+}
+
+func TestListenerCheckpointLessThan(t *testing.T) {
+	cp := &listenerCheckpoint{Block: 10, OperationPass: 3, OperationIndex: 2, ResultIndex: 1}
+	assert.True(t, cp.LessThan(&listenerCheckpoint{Block: 11, OperationPass: -1, OperationIndex: -1, ResultIndex: -1}))
+	assert.True(t, cp.LessThan(&listenerCheckpoint{Block: 10, OperationPass: 3, OperationIndex: 3, ResultIndex: 0}))
+	assert.True(t, cp.LessThan(&listenerCheckpoint{Block: 10, OperationPass: 3, OperationIndex: 2, ResultIndex: 2}))
+	assert.False(t, cp.LessThan(&listenerCheckpoint{Block: 10, OperationPass: 3, OperationIndex: 2, ResultIndex: 1}))
+	assert.False(t, cp.LessThan(&listenerCheckpoint{Block: 10, OperationPass: -1, OperationIndex: -1, ResultIndex: -1}))
+}
+
+func TestListenerHWMCheckpoint(t *testing.T) {
+	l := &listener{hwmBlock: 10}
+	assert.Equal(t, &listenerCheckpoint{Block: 10, OperationPass: -1, OperationIndex: -1, ResultIndex: -1}, l.getHWMCheckpoint(100))
+
+	delivered := &listenerCheckpoint{Block: 10, OperationPass: 3, OperationIndex: 0, ResultIndex: 1}
+	l.markDelivered(delivered)
+	assert.Equal(t, delivered, l.getHWMCheckpoint(100))
+	assert.False(t, l.isPending(delivered))
+	assert.True(t, l.isPending(&listenerCheckpoint{Block: 10, OperationPass: 3, OperationIndex: 0, ResultIndex: 2}))
+
+	l.moveHWM(11)
+	assert.Equal(t, int64(11), l.getHWMCheckpoint(100).Block)
+	assert.False(t, l.isPending(&listenerCheckpoint{Block: 10, OperationPass: 3, OperationIndex: 1, ResultIndex: 0}))
+}
+
+func TestListenerHWMCheckpointHeldBack(t *testing.T) {
+	l := &listener{hwmBlock: 100, startBlock: 20}
+	assert.Equal(t, int64(51), l.getHWMCheckpoint(51).Block)
+	assert.Equal(t, int64(20), l.getHWMCheckpoint(-10).Block)
+
+	// Delivered events are already confirmed, so are never re-delivered
+	delivered := &listenerCheckpoint{Block: 90, OperationPass: 3, OperationIndex: 0, ResultIndex: 1}
+	l.markDelivered(delivered)
+	assert.Equal(t, delivered, l.getHWMCheckpoint(51))
+}
+
+func TestListenerRewind(t *testing.T) {
+	l := &listener{hwmBlock: 100, startBlock: 20}
+	l.markDelivered(&listenerCheckpoint{Block: 95, OperationPass: 3, OperationIndex: 0, ResultIndex: 1})
+	l.rewind(96)
+	assert.Equal(t, int64(96), l.getHWM())
+	assert.NotNil(t, l.lastCheckpoint)
+
+	l.rewind(95)
+	assert.Equal(t, int64(95), l.getHWM())
+	assert.Nil(t, l.lastCheckpoint)
+
+	l.rewind(10)
+	assert.Equal(t, int64(20), l.getHWM())
+}
+
+func TestListenerResolveStartBlockLatest(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(10), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	l, err := c.buildListener(ctx, &ffcapi.EventListenerAddRequest{
+		ListenerID: fftypes.NewUUID(),
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: ffcapi.FromBlockLatest,
+			Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), l.hwmBlock)
+
+	l.resolveStartBlock(ctx)
+	assert.Equal(t, int64(10), l.hwmBlock)
+	assert.Equal(t, int64(10), l.startBlock)
+}
@@ -2,9 +2,21 @@ package tezos
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-tezosconnect/internal/msgs"
 	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/trilitech/tzgo/micheline"
+	"github.com/trilitech/tzgo/tezos"
 )
 
 // eventStream is the state we hold in memory for each eventStream
@@ -13,19 +25,212 @@ type eventStream struct {
 	ctx            context.Context
 	c              *tezosConnector
 	events         chan<- *ffcapi.ListenerEvent
+	mux            sync.Mutex
 	listeners      map[fftypes.UUID]*listener
 	headBlock      int64
 	streamLoopDone chan struct{}
 	catchup        bool
+	recentBlocks   map[int64]*scannedBlock   // the blocks scanned in the unstable head of the chain, protected by mux
+	types          map[string]micheline.Prim // the Michelson types of contract storage and big_maps, protected by mux
 }
 
-func (es *eventStream) addEventListener(_ context.Context, _ *ffcapi.EventListenerAddRequest) (*listener, error) {
-	// TODO: impl
-	return nil, nil
+// scannedBlock records a block in the unstable head of the chain that has been scanned for events, along
+// with the events delivered from it, so they can be retracted if the block is dropped in a re-org
+type scannedBlock struct {
+	hash   string
+	events []*ffcapi.ListenerEvent
 }
 
-func (es *eventStream) startEventListener(_ *listener) {
-	// TODO: impl
+func (es *eventStream) addEventListener(ctx context.Context, req *ffcapi.EventListenerAddRequest) (*listener, error) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	if _, ok := es.listeners[*req.ListenerID]; ok {
+		return nil, i18n.NewError(ctx, msgs.MsgListenerAlreadyStarted, req.ListenerID)
+	}
+
+	l, err := es.c.buildListener(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	l.resolveStartBlock(ctx)
+	l.es = es
+	es.listeners[*req.ListenerID] = l
+	return l, nil
+}
+
+// removeEventListener removes a listener from the stream, so it is no longer included in the lead group,
+// and cancels any catchup loop it is running
+func (es *eventStream) removeEventListener(listenerID *fftypes.UUID) *listener {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	l := es.listeners[*listenerID]
+	if l != nil {
+		delete(es.listeners, *listenerID)
+		l.removed = true
+		if l.cancelCatchup != nil {
+			l.cancelCatchup()
+		}
+	}
+	return l
+}
+
+func (c *tezosConnector) buildListener(ctx context.Context, req *ffcapi.EventListenerAddRequest) (*listener, error) {
+	var options map[string]interface{}
+	if req.Options != nil {
+		if err := json.Unmarshal(req.Options.Bytes(), &options); err != nil {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidListenerOptions, err)
+		}
+	}
+
+	l := &listener{
+		id: req.ListenerID,
+		c:  c,
+		config: listenerConfig{
+			name:      req.Name,
+			fromBlock: req.FromBlock,
+			options:   options,
+		},
+	}
+	if len(req.Filters) == 0 {
+		return nil, i18n.NewError(ctx, msgs.MsgMissingEventFilter)
+	}
+	signatures := make([]string, 0, len(req.Filters))
+	for _, f := range req.Filters {
+		filter, err := parseEventFilter(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		l.config.filters = append(l.config.filters, filter)
+		signatures = append(signatures, filter.signature())
+	}
+	l.config.signature = strings.Join(signatures, ";")
+
+	if req.Checkpoint != nil {
+		// Resume just after the last event delivered before the restart
+		cp, ok := req.Checkpoint.(*listenerCheckpoint)
+		if !ok {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidCheckpoint, req.Checkpoint)
+		}
+		l.hwmBlock = cp.Block
+		l.startBlock = cp.Block
+		l.lastCheckpoint = cp
+		return l, nil
+	}
+
+	switch l.config.fromBlock {
+	case ffcapi.FromBlockLatest:
+		// resolved when the listener is added to a stream, as it needs the head of the chain
+	case ffcapi.FromBlockEarliest, "":
+		l.hwmBlock = 0
+	default:
+		fromBlock, err := strconv.ParseInt(l.config.fromBlock, 10, 64)
+		if err != nil || fromBlock < 0 {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidFromBlock, l.config.fromBlock)
+		}
+		l.hwmBlock = fromBlock
+	}
+	l.startBlock = l.hwmBlock
+	return l, nil
+}
+
+// resolveStartBlock starts a new listener from "latest" at the head of the chain when it is added to a stream,
+// rather than when its options are verified, so verifying them does not wait for the node
+func (l *listener) resolveStartBlock(ctx context.Context) {
+	if l.lastCheckpoint == nil && l.config.fromBlock == ffcapi.FromBlockLatest {
+		l.hwmBlock = l.c.blockListener.getHighestBlock(ctx)
+		l.startBlock = l.hwmBlock
+	}
+}
+
+func parseEventFilter(ctx context.Context, f fftypes.JSONAny) (*eventFilter, error) {
+	var filter eventFilter
+	if err := json.Unmarshal(f.Bytes(), &filter); err != nil {
+		return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, err)
+	}
+	if filter.Kind == "" {
+		filter.Kind = filterKindEvent
+	}
+	switch filter.Kind {
+	case filterKindEvent, filterKindStorage, filterKindCall, filterKindBalance:
+		if filter.BigMap != nil || len(filter.Actions) > 0 {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("bigMap and actions only apply to '%s' filters", filterKindBigMap))
+		}
+	case filterKindBigMap:
+		if filter.BigMap == nil || *filter.BigMap < 0 {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("'%s' filters require the id of a bigMap", filterKindBigMap))
+		}
+		for _, action := range filter.Actions {
+			if !bigMapActions[action] {
+				return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("unknown big_map action '%s'", action))
+			}
+		}
+	default:
+		return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("unknown filter kind '%s'", filter.Kind))
+	}
+	if filter.Kind != filterKindEvent && (filter.Tag != "" || filter.Type != nil) {
+		return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("tag and type only apply to '%s' filters", filterKindEvent))
+	}
+	if filter.Kind != filterKindCall && len(filter.Entrypoints) > 0 {
+		return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("entrypoints only apply to '%s' filters", filterKindCall))
+	}
+	for _, ep := range filter.Entrypoints {
+		if ep == "" {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, "entrypoint names must not be empty")
+		}
+	}
+	if filter.Kind == filterKindBalance {
+		if filter.Address != "" || len(filter.Addresses) == 0 {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("'%s' filters require a list of addresses", filterKindBalance))
+		}
+		for i, a := range filter.Addresses {
+			address, err := tezos.ParseAddress(a)
+			if err != nil {
+				return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, err)
+			}
+			if !address.IsEOA() && !address.IsContract() {
+				return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("address '%s' is not an account or contract address", a))
+			}
+			filter.Addresses[i] = address.String()
+		}
+		return &filter, nil
+	} else if len(filter.Addresses) > 0 {
+		return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("addresses only apply to '%s' filters", filterKindBalance))
+	}
+
+	// The address is optional only for big_map filters
+	if filter.Address != "" || filter.Kind != filterKindBigMap {
+		address, err := tezos.ParseAddress(filter.Address)
+		if err != nil {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, err)
+		}
+		if !address.IsContract() {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, fmt.Sprintf("address '%s' is not a KT1 contract address", filter.Address))
+		}
+		filter.Address = address.String()
+	}
+	if filter.Type != nil && !filter.Type.IsValid() {
+		return nil, i18n.NewError(ctx, msgs.MsgInvalidEventFilter, "type is not a valid Micheline type")
+	}
+	return &filter, nil
+}
+
+// startEventListener decides whether a listener can join the lead group straight away, or whether it is so
+// far behind the lead group that it needs to run its own catchup loop first (so it does not hold back the
+// delivery of events for all the other listeners while it works through the old blocks)
+func (es *eventStream) startEventListener(l *listener) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	hwmBlock := l.getHWM()
+	if es.headBlock-hwmBlock > es.c.catchupThreshold {
+		log.L(es.ctx).Infof("Listener %s starting catchup loop from block %d (stream head block: %d)", l.id, hwmBlock, es.headBlock)
+		l.catchup = true
+		l.catchupLoopDone = make(chan struct{})
+		ctx, cancel := context.WithCancel(es.ctx)
+		l.cancelCatchup = cancel
+		go l.listenerCatchupLoop(ctx, cancel)
+		return
+	}
+	log.L(es.ctx).Infof("Listener %s started in lead group from block %d", l.id, hwmBlock)
 }
 
 func (es *eventStream) streamLoop() {
@@ -50,19 +255,252 @@ func (es *eventStream) streamLoop() {
 // leadGroupCatchup is called whenever the steam loop restarts, to see how far it is behind the head of the
 // chain and if it's a way behind then we catch up all this head group as one set (rather than with individual
 // catchup routines as is the case if one listener starts a way behind the pack)
-//
-//nolint:unparam
 func (es *eventStream) leadGroupCatchup() bool {
 
 	// For API status, we keep a track of whether we're in catchup mode or not
-	es.catchup = true
-	defer func() { es.catchup = false }()
+	es.setCatchup(true)
+	defer es.setCatchup(false)
 
-	// TODO: impl
-	return false
+	failCount := 0
+	for {
+		if failCount > 0 {
+			if es.c.doFailureDelay(es.ctx, failCount) {
+				log.L(es.ctx).Debugf("Stream catchup loop exiting")
+				return true
+			}
+		} else {
+			// Check if we've been asked to stop
+			select {
+			case <-es.ctx.Done():
+				log.L(es.ctx).Debugf("Stream catchup loop exiting")
+				return true
+			default:
+			}
+		}
+
+		chainHeadBlock := es.c.blockListener.getHighestBlock(es.ctx)
+		ag, fromBlock := es.buildLeadGroup(chainHeadBlock)
+
+		// Check if we're ready to exit catchup mode
+		headGap := chainHeadBlock - fromBlock
+		if headGap < es.c.catchupThreshold {
+			log.L(es.ctx).Infof("Stream head block %d (chain head: %d)", fromBlock, chainHeadBlock)
+			return false
+		}
+
+		toBlock := fromBlock + es.c.catchupPageSize - 1
+		log.L(es.ctx).Infof("Stream catchup mode processing blocks %d-%d (chain head: %d)", fromBlock, toBlock, chainHeadBlock)
+		if stopped, err := es.processBlockRange(es.ctx, ag, fromBlock, toBlock); err != nil {
+			log.L(es.ctx).Errorf("Failed to process blocks %d-%d in catchup mode: %s", fromBlock, toBlock, err)
+			failCount++
+			continue
+		} else if stopped {
+			return true
+		}
+		failCount = 0
+	}
 }
 
 func (es *eventStream) leadGroupSteadyState() bool {
-	// TODO: impl
-	return true
+	failCount := 0
+	for {
+		if failCount > 0 {
+			if es.c.doFailureDelay(es.ctx, failCount) {
+				log.L(es.ctx).Debugf("Stream loop exiting")
+				return true
+			}
+		} else {
+			select {
+			case <-time.After(es.c.eventFilterPollingInterval):
+			case <-es.ctx.Done():
+				log.L(es.ctx).Debugf("Stream loop exiting")
+				return true
+			}
+		}
+
+		if es.checkForReorg() {
+			log.L(es.ctx).Debugf("Stream loop exiting")
+			return true
+		}
+
+		chainHeadBlock := es.c.blockListener.getHighestBlock(es.ctx)
+		ag, fromBlock := es.buildLeadGroup(chainHeadBlock)
+
+		// If we've fallen too far behind the head of the chain, go back to catchup mode
+		if chainHeadBlock-fromBlock >= es.c.catchupThreshold {
+			log.L(es.ctx).Infof("Stream head block %d has fallen behind the chain head %d, entering catchup mode", fromBlock, chainHeadBlock)
+			return false
+		}
+		if fromBlock > chainHeadBlock {
+			continue
+		}
+
+		if stopped, err := es.processBlockRange(es.ctx, ag, fromBlock, chainHeadBlock); err != nil {
+			log.L(es.ctx).Errorf("Failed to process blocks %d-%d: %s", fromBlock, chainHeadBlock, err)
+			failCount++
+			continue
+		} else if stopped {
+			return true
+		}
+		failCount = 0
+	}
+}
+
+func (es *eventStream) getHeadBlock() int64 {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	return es.headBlock
+}
+
+func (es *eventStream) setCatchup(catchup bool) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	es.catchup = catchup
+}
+
+// buildLeadGroup returns the set of listeners that are in the lead group (not in their own catchup loop)
+// along with the first block that needs to be scanned for that group. When there are no listeners in the
+// lead group, the stream simply follows the head of the chain.
+func (es *eventStream) buildLeadGroup(chainHeadBlock int64) ([]*listener, int64) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	ag := make([]*listener, 0, len(es.listeners))
+	fromBlock := int64(-1)
+	for _, l := range es.listeners {
+		if l.catchup {
+			continue
+		}
+		ag = append(ag, l)
+		if hwm := l.getHWM(); fromBlock < 0 || hwm < fromBlock {
+			fromBlock = hwm
+		}
+	}
+	if fromBlock < 0 {
+		if chainHeadBlock > es.headBlock {
+			es.headBlock = chainHeadBlock
+		}
+		fromBlock = es.headBlock + 1
+	}
+	return ag, fromBlock
+}
+
+// processBlockRange scans a range of blocks for the supplied listeners and dispatches the events found,
+// then moves the listeners and the stream forwards to the last block scanned.
+// Returns true if the context was cancelled while dispatching, as the stream was stopped or the listener of a
+// catchup loop was removed.
+func (es *eventStream) processBlockRange(ctx context.Context, ag []*listener, fromBlock, toBlock int64) (bool, error) {
+	events, blocks, err := es.getBlockRangeEvents(ctx, ag, fromBlock, toBlock)
+	if err != nil {
+		return false, err
+	}
+	lastBlock := fromBlock - 1
+	if len(blocks) > 0 {
+		lastBlock = blocks[len(blocks)-1].number
+	}
+	es.recordScannedBlocks(blocks)
+
+	listeners := make(map[fftypes.UUID]*listener, len(ag))
+	for _, l := range ag {
+		listeners[*l.id] = l
+	}
+	for _, event := range events {
+		select {
+		case es.events <- event:
+		case <-ctx.Done():
+			return true, nil
+		}
+		listeners[*event.Event.ID.ListenerID].markDelivered(event.Checkpoint.(*listenerCheckpoint))
+		es.recordDeliveredEvent(event)
+	}
+	for _, l := range ag {
+		l.moveHWM(lastBlock + 1)
+	}
+	es.mux.Lock()
+	if lastBlock > es.headBlock {
+		es.headBlock = lastBlock
+	}
+	// We only need to remember the blocks that could still be replaced in a re-org
+	for number := range es.recentBlocks {
+		if number <= es.headBlock-es.c.checkpointBlockGap {
+			delete(es.recentBlocks, number)
+		}
+	}
+	es.mux.Unlock()
+	return false, nil
+}
+
+func (es *eventStream) recordScannedBlocks(blocks []*minimalBlockInfo) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	for _, block := range blocks {
+		if sb := es.recentBlocks[block.number]; sb == nil || sb.hash != block.hash {
+			es.recentBlocks[block.number] = &scannedBlock{hash: block.hash}
+		}
+	}
+}
+
+func (es *eventStream) recordDeliveredEvent(event *ffcapi.ListenerEvent) {
+	es.mux.Lock()
+	defer es.mux.Unlock()
+	if sb := es.recentBlocks[event.Checkpoint.(*listenerCheckpoint).Block]; sb != nil && sb.hash == event.Event.ID.BlockHash {
+		sb.events = append(sb.events, event)
+	}
+}
+
+// checkForReorg compares the blocks we have recently scanned against the canonical chain maintained by the
+// block listener. If any of them have been replaced, we retract the events delivered from the replaced blocks
+// (latest first) and rewind all the listeners, so that the new blocks are scanned and the events re-delivered.
+// Returns true if the stream was stopped while dispatching.
+func (es *eventStream) checkForReorg() bool {
+	es.mux.Lock()
+	forkBlock := int64(-1)
+	for number, sb := range es.recentBlocks {
+		if hash, ok := es.c.blockListener.getCanonicalBlockHash(number); ok && hash != sb.hash && (forkBlock < 0 || number < forkBlock) {
+			forkBlock = number
+		}
+	}
+	if forkBlock < 0 {
+		es.mux.Unlock()
+		return false
+	}
+
+	replaced := make([]int64, 0)
+	for number := range es.recentBlocks {
+		if number >= forkBlock {
+			replaced = append(replaced, number)
+		}
+	}
+	sort.Slice(replaced, func(i, j int) bool { return replaced[i] > replaced[j] })
+	removed := make([]*ffcapi.ListenerEvent, 0)
+	for _, number := range replaced {
+		events := es.recentBlocks[number].events
+		for i := len(events) - 1; i >= 0; i-- {
+			removed = append(removed, &ffcapi.ListenerEvent{
+				Event:   &ffcapi.Event{ID: events[i].Event.ID},
+				Removed: true,
+			})
+		}
+		delete(es.recentBlocks, number)
+	}
+	listeners := make([]*listener, 0, len(es.listeners))
+	for _, l := range es.listeners {
+		listeners = append(listeners, l)
+	}
+	if es.headBlock >= forkBlock {
+		es.headBlock = forkBlock - 1
+	}
+	es.mux.Unlock()
+
+	log.L(es.ctx).Infof("Re-org detected from block %d, retracting %d events and rescanning", forkBlock, len(removed))
+	for _, l := range listeners {
+		l.rewind(forkBlock)
+	}
+	for _, event := range removed {
+		select {
+		case es.events <- event:
+		case <-es.ctx.Done():
+			return true
+		}
+	}
+	return false
 }
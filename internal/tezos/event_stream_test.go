@@ -0,0 +1,798 @@
+package tezos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-tezosconnect/mocks/tzrpcbackendmocks"
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/trilitech/tzgo/micheline"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+const testEventContract = "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s"
+
+func testEventResult(source, tag string, payload micheline.Prim) *rpc.InternalResult {
+	return &rpc.InternalResult{
+		Kind:    tezos.OpTypeEvent,
+		Source:  tezos.MustParseAddress(source),
+		Tag:     tag,
+		Type:    micheline.NewCode(micheline.T_STRING),
+		Payload: payload,
+		Result: rpc.OperationResult{
+			Status: tezos.OpStatusApplied,
+		},
+	}
+}
+
+func testBlockWithOps(level int64, ops ...*rpc.Operation) *rpc.Block {
+	return &rpc.Block{
+		Hash: tezos.MustParseBlockHash("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg"),
+		Header: rpc.BlockHeader{
+			Predecessor: tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL"),
+			Level:       level,
+			Timestamp:   time.Unix(1700000000+level, 0),
+		},
+		Operations: [][]*rpc.Operation{{}, {}, {}, ops},
+	}
+}
+
+func testEventOperation(results ...*rpc.InternalResult) *rpc.Operation {
+	return &rpc.Operation{
+		Hash: tezos.MustParseOpHash("op13B8GtoK1UAx8p67L8y4huPqjay9yzRrpSFLTiY9kjJsrF5uV"),
+		Contents: rpc.OperationList{
+			&rpc.Transaction{
+				Manager: rpc.Manager{
+					Generic: rpc.Generic{
+						OpKind: tezos.OpTypeTransaction,
+						Metadata: rpc.OperationMetadata{
+							Result: rpc.OperationResult{
+								Status: tezos.OpStatusApplied,
+							},
+							InternalResults: results,
+						},
+					},
+				},
+				Destination: tezos.MustParseAddress(testEventContract),
+			},
+		},
+	}
+}
+
+func mockBlockLevel(mRPC *tzrpcbackendmocks.RpcClient, level int64) *mock.Call {
+	return mRPC.On("GetBlock", mock.Anything, mock.MatchedBy(func(blockNumber *fftypes.FFBigInt) bool {
+		return blockNumber.Int64() == level
+	}))
+}
+
+func startTestStream(t *testing.T, c *tezosConnector, listeners ...*ffcapi.EventListenerAddRequest) (chan *ffcapi.ListenerEvent, context.CancelFunc, *eventStream) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan *ffcapi.ListenerEvent)
+	streamID := fftypes.NewUUID()
+	for _, l := range listeners {
+		l.StreamID = streamID
+	}
+	_, _, err := c.EventStreamStart(ctx, &ffcapi.EventStreamStartRequest{
+		ID:               streamID,
+		StreamContext:    ctx,
+		EventStream:      events,
+		BlockListener:    make(chan *ffcapi.BlockHashEvent, 100),
+		InitialListeners: listeners,
+	})
+	assert.NoError(t, err)
+	return events, cancel, c.eventStreams[*streamID]
+}
+
+func TestEventStreamDeliversEmittedEvents(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(2), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1, testEventOperation(
+		testEventResult("KT18g4ExUQ8Ns4yPfg9CGoR66m5wVqSSvhJv", "transfer", micheline.NewString("other")),
+		testEventResult(testEventContract, "ignored", micheline.NewString("ignored")),
+		testEventResult(testEventContract, "transfer", micheline.NewString("hello")),
+	)), nil)
+	mockBlockLevel(mRPC, 2).Return(testBlockWithOps(2, testEventOperation(
+		testEventResult(testEventContract, "transfer", micheline.NewString("world")),
+	)), nil)
+	mockBlockLevel(mRPC, 3).Return(nil, errors.New("status 404")).Maybe()
+
+	listenerID := fftypes.NewUUID()
+	events, cancel, es := startTestStream(t, c, &ffcapi.EventListenerAddRequest{
+		ListenerID: listenerID,
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "1",
+			Filters: []fftypes.JSONAny{
+				*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `","tag":"transfer","type":{"prim":"string"}}`),
+			},
+		},
+	})
+
+	e := <-events
+	assert.Equal(t, listenerID, e.Event.ID.ListenerID)
+	assert.Equal(t, "transfer", e.Event.ID.Signature)
+	assert.Equal(t, uint64(1), e.Event.ID.BlockNumber.Uint64())
+	assert.Equal(t, uint64(0), e.Event.ID.TransactionIndex.Uint64())
+	assert.Equal(t, uint64(3), e.Event.ID.LogIndex.Uint64())
+	assert.JSONEq(t, `"hello"`, e.Event.Data.String())
+	assert.Equal(t, testEventContract, e.Event.Info.(*eventInfo).Address)
+	assert.Equal(t, int64(1700000001), e.Event.ID.Timestamp.Time().Unix())
+
+	e = <-events
+	assert.Equal(t, uint64(2), e.Event.ID.BlockNumber.Uint64())
+	assert.Equal(t, uint64(1), e.Event.ID.LogIndex.Uint64())
+	assert.JSONEq(t, `"world"`, e.Event.Data.String())
+	assert.False(t, e.Checkpoint.LessThan(&listenerCheckpoint{Block: 1, OperationPass: 3, OperationIndex: 0, ResultIndex: 3}))
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func testContractCallOperation(storage micheline.Prim, lazyStorageDiff string) *rpc.Operation {
+	return &rpc.Operation{
+		Hash: tezos.MustParseOpHash("op13B8GtoK1UAx8p67L8y4huPqjay9yzRrpSFLTiY9kjJsrF5uV"),
+		Contents: rpc.OperationList{
+			&rpc.Transaction{
+				Manager: rpc.Manager{
+					Generic: rpc.Generic{
+						OpKind: tezos.OpTypeTransaction,
+						Metadata: rpc.OperationMetadata{
+							Result: rpc.OperationResult{
+								Status:          tezos.OpStatusApplied,
+								Storage:         &storage,
+								LazyStorageDiff: json.RawMessage(lazyStorageDiff),
+							},
+						},
+					},
+				},
+				Destination: tezos.MustParseAddress(testEventContract),
+			},
+		},
+	}
+}
+
+func TestEventStreamStorageAndBigMapChanges(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+
+	keyHash := tezos.NewExprHash(make([]byte, 32)).String()
+	lazyStorageDiff := `[
+		{"kind":"big_map","id":"42","diff":{"action":"update","updates":[
+			{"key_hash":"` + keyHash + `","key":{"string":"alice"},"value":{"int":"10"}},
+			{"key_hash":"` + keyHash + `","key":{"string":"bob"}}
+		]}},
+		{"kind":"big_map","id":"43","diff":{"action":"alloc","updates":[
+			{"key_hash":"` + keyHash + `","key":{"int":"1"},"value":{"string":"temp"}}
+		],"key_type":{"prim":"nat"},"value_type":{"prim":"string"}}}
+	]`
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(1), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1, testContractCallOperation(micheline.NewInt64(7), lazyStorageDiff)), nil)
+	mockBlockLevel(mRPC, 2).Return(nil, errors.New("status 404")).Maybe()
+	mRPC.On("GetContractScript", mock.Anything, tezos.MustParseAddress(testEventContract)).Return(&micheline.Script{
+		Code: micheline.Code{
+			Param:   micheline.NewCode(micheline.K_PARAMETER, micheline.NewCode(micheline.T_UNIT)),
+			Storage: micheline.NewCode(micheline.K_STORAGE, micheline.NewCodeAnno(micheline.T_INT, "%counter")),
+		},
+	}, nil).Once()
+	mRPC.On("GetBigmapInfo", mock.Anything, int64(42), rpc.BlockLevel(1)).Return(&rpc.BigmapInfo{
+		KeyType:   micheline.NewCode(micheline.T_STRING),
+		ValueType: micheline.NewCode(micheline.T_NAT),
+	}, nil).Once()
+	mRPC.On("GetContractStorage", mock.Anything, tezos.MustParseAddress(testEventContract), rpc.BlockLevel(0)).Return(micheline.NewInt64(6), nil).Once()
+
+	storageListenerID := fftypes.NewUUID()
+	bigMapListenerID := fftypes.NewUUID()
+	allocListenerID := fftypes.NewUUID()
+	events, cancel, es := startTestStream(t, c,
+		&ffcapi.EventListenerAddRequest{
+			ListenerID: storageListenerID,
+			EventListenerOptions: ffcapi.EventListenerOptions{
+				FromBlock: "1",
+				Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"kind":"storage","address":"` + testEventContract + `"}`)},
+			},
+		},
+		&ffcapi.EventListenerAddRequest{
+			ListenerID: bigMapListenerID,
+			EventListenerOptions: ffcapi.EventListenerOptions{
+				FromBlock: "1",
+				Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"kind":"bigmap","bigMap":42,"address":"` + testEventContract + `"}`)},
+			},
+		},
+		&ffcapi.EventListenerAddRequest{
+			ListenerID: allocListenerID,
+			EventListenerOptions: ffcapi.EventListenerOptions{
+				FromBlock: "1",
+				Filters: []fftypes.JSONAny{
+					*fftypes.JSONAnyPtr(`{"kind":"bigmap","bigMap":0}`),
+					*fftypes.JSONAnyPtr(`{"kind":"bigmap","bigMap":43,"actions":["alloc","update"]}`),
+				},
+			},
+		},
+	)
+
+	received := map[fftypes.UUID]*ffcapi.ListenerEvent{}
+	for i := 0; i < 3; i++ {
+		e := <-events
+		received[*e.Event.ID.ListenerID] = e
+	}
+
+	e := received[*storageListenerID]
+	assert.Equal(t, "storage", e.Event.ID.Signature)
+	assert.Equal(t, testEventContract, e.Event.Info.(*eventInfo).Address)
+	assert.JSONEq(t, `{"storage":{"counter":"7"}}`, e.Event.Data.String())
+
+	e = received[*bigMapListenerID]
+	assert.Equal(t, "bigmap", e.Event.ID.Signature)
+	assert.JSONEq(t, `{"diffs":[
+		{"bigMap":42,"action":"update","keyHash":"`+keyHash+`","key":"alice","value":"10"},
+		{"bigMap":42,"action":"remove","keyHash":"`+keyHash+`","key":"bob"}
+	]}`, e.Event.Data.String())
+
+	// The types of a newly allocated big_map come from the alloc diff
+	e = received[*allocListenerID]
+	assert.JSONEq(t, `{"diffs":[
+		{"bigMap":43,"action":"alloc","keyType":{"prim":"nat"},"valueType":{"prim":"string"}},
+		{"bigMap":43,"action":"update","keyHash":"`+keyHash+`","key":"1","value":"temp"}
+	]}`, e.Event.Data.String())
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func TestEventStreamStorageUnchanged(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(1), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1,
+		testContractCallOperation(micheline.NewInt64(6), "[]"),
+		testContractCallOperation(micheline.NewInt64(7), "[]"),
+		testContractCallOperation(micheline.NewInt64(7), "[]"),
+	), nil)
+	mockBlockLevel(mRPC, 2).Return(nil, errors.New("status 404")).Maybe()
+	mRPC.On("GetContractScript", mock.Anything, tezos.MustParseAddress(testEventContract)).Return(&micheline.Script{
+		Code: micheline.Code{
+			Param:   micheline.NewCode(micheline.K_PARAMETER, micheline.NewCode(micheline.T_UNIT)),
+			Storage: micheline.NewCode(micheline.K_STORAGE, micheline.NewCodeAnno(micheline.T_INT, "%counter")),
+		},
+	}, nil).Once()
+	// The storage before the block is only fetched once, and later calls are compared with the earlier ones
+	mRPC.On("GetContractStorage", mock.Anything, tezos.MustParseAddress(testEventContract), rpc.BlockLevel(0)).Return(micheline.NewInt64(6), nil).Once()
+
+	// The calls that do not change the storage fall through to the call filter
+	events, cancel, es := startTestStream(t, c, &ffcapi.EventListenerAddRequest{
+		ListenerID: fftypes.NewUUID(),
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "1",
+			Filters: []fftypes.JSONAny{
+				*fftypes.JSONAnyPtr(`{"kind":"storage","address":"` + testEventContract + `"}`),
+				*fftypes.JSONAnyPtr(`{"kind":"call","address":"` + testEventContract + `"}`),
+			},
+		},
+	})
+	for i, signature := range []string{"call", "storage", "call"} {
+		e := <-events
+		assert.Equal(t, signature, e.Event.ID.Signature)
+		assert.Equal(t, uint64(i), e.Event.ID.TransactionIndex.Uint64())
+	}
+
+	cancel()
+	<-es.streamLoopDone
+	mRPC.AssertExpectations(t)
+}
+
+func TestResolveStorageChangeLookup(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+	es := &eventStream{c: c}
+
+	storage := micheline.NewInt64(1)
+	r := &operationResult{
+		destination: tezos.MustParseAddress(testEventContract),
+		result:      rpc.OperationResult{Status: tezos.OpStatusApplied, Storage: &storage},
+	}
+	mRPC.On("GetContractStorage", ctx, r.destination, rpc.BlockLevel(9)).Return(micheline.InvalidPrim, errors.New("pop")).Once()
+	err := es.resolveStorageChange(ctx, r, 10, map[string]micheline.Prim{})
+	assert.Regexp(t, "pop", err)
+	assert.False(t, r.storageResolved)
+
+	// A contract originated in the block had no storage before it
+	mRPC.On("GetContractStorage", ctx, r.destination, rpc.BlockLevel(9)).Return(micheline.InvalidPrim, errors.New("status 404")).Once()
+	err = es.resolveStorageChange(ctx, r, 10, map[string]micheline.Prim{})
+	assert.NoError(t, err)
+	assert.True(t, r.storageResolved)
+	assert.True(t, r.storageChanged)
+}
+
+func testTransaction(source, destination string, amount int64, params *micheline.Parameters, internal ...*rpc.InternalResult) *rpc.Transaction {
+	return &rpc.Transaction{
+		Manager: rpc.Manager{
+			Generic: rpc.Generic{
+				OpKind: tezos.OpTypeTransaction,
+				Metadata: rpc.OperationMetadata{
+					Result: rpc.OperationResult{
+						Status: tezos.OpStatusApplied,
+					},
+					InternalResults: internal,
+				},
+			},
+			Source: tezos.MustParseAddress(source),
+		},
+		Destination: tezos.MustParseAddress(destination),
+		Amount:      amount,
+		Parameters:  params,
+	}
+}
+
+func TestEventStreamEntrypointCalls(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+
+	const sender = "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"
+	const router = "KT18g4ExUQ8Ns4yPfg9CGoR66m5wVqSSvhJv"
+	destination := tezos.MustParseAddress(testEventContract)
+	// A call to the default entrypoint of a contract without one is a call to the entrypoint selected by the branches
+	transfer := &micheline.Parameters{
+		Entrypoint: micheline.DEFAULT,
+		Value: micheline.NewCode(micheline.D_LEFT, micheline.NewPair(
+			micheline.NewString(sender),
+			micheline.NewNat(big.NewInt(10)),
+		)),
+	}
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1,
+		&rpc.Operation{
+			Hash: tezos.MustParseOpHash("op13B8GtoK1UAx8p67L8y4huPqjay9yzRrpSFLTiY9kjJsrF5uV"),
+			Contents: rpc.OperationList{
+				testTransaction(sender, router, 0, nil, &rpc.InternalResult{
+					Kind:        tezos.OpTypeTransaction,
+					Source:      tezos.MustParseAddress(router),
+					Destination: &destination,
+					Amount:      5,
+					Parameters:  transfer,
+					Result:      rpc.OperationResult{Status: tezos.OpStatusApplied},
+				}),
+			},
+		},
+		&rpc.Operation{
+			Hash: tezos.MustParseOpHash("op13B8GtoK1UAx8p67L8y4huPqjay9yzRrpSFLTiY9kjJsrF5uV"),
+			Contents: rpc.OperationList{
+				testTransaction(sender, testEventContract, 1000, &micheline.Parameters{
+					Entrypoint: "mint",
+					Value:      micheline.NewNat(big.NewInt(3)),
+				}),
+			},
+		},
+	), nil)
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(1), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 2).Return(nil, errors.New("status 404")).Maybe()
+	mRPC.On("GetContractScript", mock.Anything, destination).Return(&micheline.Script{
+		Code: micheline.Code{
+			Param: micheline.NewCode(micheline.K_PARAMETER, micheline.NewCode(micheline.T_OR,
+				micheline.NewPairType(micheline.NewCodeAnno(micheline.T_ADDRESS, "%to"), micheline.NewCodeAnno(micheline.T_NAT, "%value"), "%transfer"),
+				micheline.NewCodeAnno(micheline.T_NAT, "%mint"),
+			)),
+			Storage: micheline.NewCode(micheline.K_STORAGE, micheline.NewCode(micheline.T_UNIT)),
+		},
+	}, nil).Once()
+
+	transferListenerID := fftypes.NewUUID()
+	callListenerID := fftypes.NewUUID()
+	events, cancel, es := startTestStream(t, c,
+		&ffcapi.EventListenerAddRequest{
+			ListenerID: transferListenerID,
+			EventListenerOptions: ffcapi.EventListenerOptions{
+				FromBlock: "1",
+				Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"kind":"call","address":"` + testEventContract + `","entrypoints":["transfer"]}`)},
+			},
+		},
+		&ffcapi.EventListenerAddRequest{
+			ListenerID: callListenerID,
+			EventListenerOptions: ffcapi.EventListenerOptions{
+				FromBlock: "1",
+				Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"kind":"call","address":"` + testEventContract + `"}`)},
+			},
+		},
+	)
+
+	received := map[fftypes.UUID][]*ffcapi.ListenerEvent{}
+	for i := 0; i < 3; i++ {
+		e := <-events
+		received[*e.Event.ID.ListenerID] = append(received[*e.Event.ID.ListenerID], e)
+	}
+
+	transferData := `{"sender":"` + router + `","source":"` + sender + `","amount":"5","entrypoint":"transfer","parameters":{"to":"` + sender + `","value":"10"}}`
+	assert.Len(t, received[*transferListenerID], 1)
+	e := received[*transferListenerID][0]
+	assert.Equal(t, "call", e.Event.ID.Signature)
+	assert.Equal(t, uint64(1), e.Event.ID.LogIndex.Uint64())
+	assert.Equal(t, testEventContract, e.Event.Info.(*eventInfo).Address)
+	assert.JSONEq(t, transferData, e.Event.Data.String())
+
+	assert.Len(t, received[*callListenerID], 2)
+	assert.JSONEq(t, transferData, received[*callListenerID][0].Event.Data.String())
+	e = received[*callListenerID][1]
+	assert.Equal(t, uint64(1), e.Event.ID.TransactionIndex.Uint64())
+	assert.JSONEq(t, `{"sender":"`+sender+`","source":"`+sender+`","amount":"1000","entrypoint":"mint","parameters":"3"}`, e.Event.Data.String())
+
+	cancel()
+	<-es.streamLoopDone
+	mRPC.AssertExpectations(t)
+}
+
+func TestEventStreamBalanceChanges(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+
+	const account = "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"
+	accountAddress := tezos.MustParseAddress(account)
+	contractAddress := tezos.MustParseAddress(testEventContract)
+	fees := rpc.BalanceUpdates{
+		{Kind: rpc.CONTRACT, Contract: accountAddress, Change: -400},
+		{Kind: "accumulator", Category: "block fees", Change: 400},
+	}
+	failed := testTransaction(account, testEventContract, 100, nil)
+	failed.Metadata.BalanceUpdates = fees
+	failed.Metadata.Result.Status = tezos.OpStatusFailed
+	// A successful call to a contract, which pays the account back from its own balance
+	payout := testTransaction(account, testEventContract, 0, nil, &rpc.InternalResult{
+		Kind:        tezos.OpTypeTransaction,
+		Source:      contractAddress,
+		Destination: &accountAddress,
+		Amount:      50,
+		Result: rpc.OperationResult{
+			Status: tezos.OpStatusApplied,
+			BalanceUpdates: rpc.BalanceUpdates{
+				{Kind: rpc.CONTRACT, Contract: contractAddress, Change: -50},
+				{Kind: rpc.CONTRACT, Contract: accountAddress, Change: 50},
+			},
+		},
+	})
+	payout.Metadata.BalanceUpdates = fees
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1,
+		&rpc.Operation{
+			Hash:     tezos.MustParseOpHash("op13B8GtoK1UAx8p67L8y4huPqjay9yzRrpSFLTiY9kjJsrF5uV"),
+			Contents: rpc.OperationList{failed},
+		},
+		&rpc.Operation{
+			Hash:     tezos.MustParseOpHash("op13B8GtoK1UAx8p67L8y4huPqjay9yzRrpSFLTiY9kjJsrF5uV"),
+			Contents: rpc.OperationList{payout},
+		},
+	), nil)
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(1), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 2).Return(nil, errors.New("status 404")).Maybe()
+
+	events, cancel, es := startTestStream(t, c, &ffcapi.EventListenerAddRequest{
+		ListenerID: fftypes.NewUUID(),
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "1",
+			Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"kind":"balance","addresses":["` + account + `"]}`)},
+		},
+	})
+
+	// The failed operation only pays its fee
+	e := <-events
+	assert.Equal(t, "balance", e.Event.ID.Signature)
+	assert.Equal(t, uint64(0), e.Event.ID.LogIndex.Uint64())
+	assert.JSONEq(t, `{"changes":[{"address":"`+account+`","change":"-400","reason":"fee","category":"block fees"}]}`, e.Event.Data.String())
+
+	e = <-events
+	assert.Equal(t, uint64(1), e.Event.ID.TransactionIndex.Uint64())
+	assert.Equal(t, uint64(0), e.Event.ID.LogIndex.Uint64())
+	assert.JSONEq(t, `{"changes":[{"address":"`+account+`","change":"-400","reason":"fee","category":"block fees"}]}`, e.Event.Data.String())
+
+	e = <-events
+	assert.Equal(t, uint64(1), e.Event.ID.LogIndex.Uint64())
+	assert.JSONEq(t, `{"changes":[{"address":"`+account+`","change":"50","reason":"transfer","counterparty":"`+testEventContract+`"}]}`, e.Event.Data.String())
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func TestEventStreamNoBlockTimestamps(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+	c.eventBlockTimestamps = false
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(1), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1, testEventOperation(
+		testEventResult(testEventContract, "transfer", micheline.NewString("hello")),
+		testEventResult(testEventContract, "transfer", micheline.NewString("world")),
+	)), nil).Once()
+	mockBlockLevel(mRPC, 2).Return(nil, errors.New("status 404")).Maybe()
+
+	events, cancel, es := startTestStream(t, c, &ffcapi.EventListenerAddRequest{
+		ListenerID: fftypes.NewUUID(),
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "1",
+			Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		e := <-events
+		assert.Nil(t, e.Event.ID.Timestamp)
+	}
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func TestEventStreamResumesFromCheckpoint(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(1), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1, testEventOperation(
+		testEventResult(testEventContract, "transfer", micheline.NewString("delivered")),
+		testEventResult(testEventContract, "transfer", micheline.NewString("pending")),
+	)), nil)
+	mockBlockLevel(mRPC, 2).Return(nil, errors.New("status 404")).Maybe()
+
+	listenerID := fftypes.NewUUID()
+	events, cancel, es := startTestStream(t, c, &ffcapi.EventListenerAddRequest{
+		ListenerID: listenerID,
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "0",
+			Filters: []fftypes.JSONAny{
+				*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`),
+			},
+		},
+		Checkpoint: &listenerCheckpoint{Block: 1, OperationPass: 3, OperationIndex: 0, ResultIndex: 1},
+	})
+
+	e := <-events
+	assert.Equal(t, uint64(2), e.Event.ID.LogIndex.Uint64())
+	assert.JSONEq(t, `"pending"`, e.Event.Data.String())
+
+	// The checkpoint is held back within the unstable head of the chain, but not behind the delivered events
+	for {
+		res, _, err := c.EventListenerHWM(es.ctx, &ffcapi.EventListenerHWMRequest{StreamID: es.id, ListenerID: listenerID})
+		assert.NoError(t, err)
+		if es.listeners[*listenerID].getHWM() == 2 {
+			assert.Equal(t, e.Checkpoint, res.Checkpoint)
+			break
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func TestEventStreamCatchupThenSteadyState(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+	c.catchupPageSize = 2
+	c.catchupThreshold = 2
+	c.retry.InitialDelay = 1 * time.Millisecond
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(5), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 0).Return(nil, errors.New("pop")).Once()
+	for i := int64(0); i < 5; i++ {
+		mockBlockLevel(mRPC, i).Return(testBlockWithOps(i), nil)
+	}
+	mockBlockLevel(mRPC, 5).Return(testBlockWithOps(5, testEventOperation(
+		testEventResult(testEventContract, "transfer", micheline.NewString("hello")),
+	)), nil)
+	mockBlockLevel(mRPC, 6).Return(nil, errors.New("status 404")).Maybe()
+
+	events, cancel, es := startTestStream(t, c, &ffcapi.EventListenerAddRequest{
+		ListenerID: fftypes.NewUUID(),
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: ffcapi.FromBlockEarliest,
+			Filters: []fftypes.JSONAny{
+				*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`),
+			},
+		},
+	})
+
+	e := <-events
+	assert.Equal(t, uint64(5), e.Event.ID.BlockNumber.Uint64())
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func TestEventStreamListenerCatchupLoop(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+	c.catchupPageSize = 2
+	c.catchupThreshold = 2
+	c.retry.InitialDelay = 1 * time.Millisecond
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(5), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 2).Return(nil, errors.New("pop")).Once()
+	for i := int64(0); i < 5; i++ {
+		if i == 1 {
+			continue
+		}
+		mockBlockLevel(mRPC, i).Return(testBlockWithOps(i), nil)
+	}
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1, testEventOperation(
+		testEventResult(testEventContract, "old", micheline.NewString("old")),
+	)), nil)
+	mockBlockLevel(mRPC, 5).Return(testBlockWithOps(5, testEventOperation(
+		testEventResult(testEventContract, "new", micheline.NewString("new")),
+	)), nil)
+	mockBlockLevel(mRPC, 6).Return(nil, errors.New("status 404")).Maybe()
+
+	filters := []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)}
+	leadListenerID := fftypes.NewUUID()
+	catchupListenerID := fftypes.NewUUID()
+	events, cancel, es := startTestStream(t, c,
+		&ffcapi.EventListenerAddRequest{
+			ListenerID:           leadListenerID,
+			EventListenerOptions: ffcapi.EventListenerOptions{FromBlock: "5", Filters: filters},
+		},
+		&ffcapi.EventListenerAddRequest{
+			ListenerID:           catchupListenerID,
+			EventListenerOptions: ffcapi.EventListenerOptions{FromBlock: "0", Filters: filters},
+		},
+	)
+	catchupListener := es.listeners[*catchupListenerID]
+	assert.NotNil(t, catchupListener.catchupLoopDone)
+	assert.Nil(t, es.listeners[*leadListenerID].catchupLoopDone)
+
+	received := map[fftypes.UUID][]string{}
+	for i := 0; i < 3; i++ {
+		e := <-events
+		received[*e.Event.ID.ListenerID] = append(received[*e.Event.ID.ListenerID], e.Event.ID.Signature)
+	}
+	assert.Equal(t, []string{"new"}, received[*leadListenerID])
+	assert.Equal(t, []string{"old", "new"}, received[*catchupListenerID])
+
+	<-catchupListener.catchupLoopDone
+	es.mux.Lock()
+	assert.False(t, catchupListener.catchup)
+	es.mux.Unlock()
+
+	cancel()
+	_, _, err := c.EventStreamStopped(context.Background(), &ffcapi.EventStreamStoppedRequest{ID: es.id})
+	assert.NoError(t, err)
+}
+
+func TestEventStreamReorg(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(1), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 0).Return(testBlockWithOps(0), nil)
+	mockBlockLevel(mRPC, 1).Return(testBlockWithOps(1, testEventOperation(
+		testEventResult(testEventContract, "transfer", micheline.NewString("dropped")),
+	)), nil).Once()
+	forkedBlock := testBlockWithOps(1, testEventOperation(
+		testEventResult(testEventContract, "transfer", micheline.NewString("replacement")),
+	))
+	forkedBlock.Hash = tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL")
+	mockBlockLevel(mRPC, 1).Return(forkedBlock, nil)
+	mockBlockLevel(mRPC, 2).Return(nil, errors.New("status 404")).Maybe()
+
+	events, cancel, es := startTestStream(t, c, &ffcapi.EventListenerAddRequest{
+		ListenerID: fftypes.NewUUID(),
+		EventListenerOptions: ffcapi.EventListenerOptions{
+			FromBlock: "0",
+			Filters: []fftypes.JSONAny{
+				*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`),
+			},
+		},
+	})
+
+	e := <-events
+	assert.False(t, e.Removed)
+	assert.JSONEq(t, `"dropped"`, e.Event.Data.String())
+
+	// The block listener sees block 1 replaced at the head of the chain
+	c.blockListener.mux.Lock()
+	c.blockListener.canonicalHashes[1] = forkedBlock.Hash.String()
+	c.blockListener.mux.Unlock()
+
+	removed := <-events
+	assert.True(t, removed.Removed)
+	assert.Equal(t, e.Event.ID, removed.Event.ID)
+
+	e = <-events
+	assert.False(t, e.Removed)
+	assert.Equal(t, forkedBlock.Hash.String(), e.Event.ID.BlockHash)
+	assert.JSONEq(t, `"replacement"`, e.Event.Data.String())
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func TestEventStreamNoListenersFollowsHead(t *testing.T) {
+	_, c, mRPC, done := newTestConnector(t)
+	defer done()
+	c.eventFilterPollingInterval = 1 * time.Millisecond
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(100), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	_, cancel, es := startTestStream(t, c)
+	for {
+		es.mux.Lock()
+		headBlock := es.headBlock
+		es.mux.Unlock()
+		if headBlock == 100 {
+			break
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	cancel()
+	<-es.streamLoopDone
+}
+
+func TestEventStreamBadCheckpoint(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(100), nil).Maybe()
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	_, _, err := c.EventStreamStart(ctx, &ffcapi.EventStreamStartRequest{
+		ID:            fftypes.NewUUID(),
+		StreamContext: ctx,
+		InitialListeners: []*ffcapi.EventListenerAddRequest{
+			{
+				ListenerID: fftypes.NewUUID(),
+				EventListenerOptions: ffcapi.EventListenerOptions{
+					Filters: []fftypes.JSONAny{*fftypes.JSONAnyPtr(`{"address":"` + testEventContract + `"}`)},
+				},
+				Checkpoint: &ffcapi.BlockListenerCheckpoint{Block: 12345},
+			},
+		},
+	})
+	assert.Regexp(t, "FF23039", err)
+}
+
+func TestEventStreamBadFilter(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(100), nil).Maybe()
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	_, _, err := c.EventStreamStart(ctx, &ffcapi.EventStreamStartRequest{
+		ID:            fftypes.NewUUID(),
+		StreamContext: ctx,
+		InitialListeners: []*ffcapi.EventListenerAddRequest{
+			{
+				ListenerID: fftypes.NewUUID(),
+				EventListenerOptions: ffcapi.EventListenerOptions{
+					FromBlock: "0",
+					Filters:   []fftypes.JSONAny{*fftypes.JSONAnyPtr(`[]`)},
+				},
+			},
+		},
+	})
+	assert.Regexp(t, "FF23036", err)
+}
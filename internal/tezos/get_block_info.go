@@ -76,6 +76,20 @@ func (c *tezosConnector) BlockInfoByHash(ctx context.Context, req *ffcapi.BlockI
 	return res, "", nil
 }
 
+// getCanonicalBlock gets a block by number, making sure we do not use a cached block that the block listener
+// has since seen replaced in a re-org (the block cache is keyed on the number as well as the hash)
+func (c *tezosConnector) getCanonicalBlock(ctx context.Context, blockNumber int64) (*rpc.Block, ffcapi.ErrorReason, error) {
+	block, reason, err := c.getBlockInfoByNumber(ctx, blockNumber, true, "")
+	if err != nil || block == nil {
+		return block, reason, err
+	}
+	if hash, ok := c.blockListener.getCanonicalBlockHash(blockNumber); ok && hash != block.Hash.String() {
+		log.L(ctx).Debugf("Cached block %d / %s replaced by %s in the canonical chain", blockNumber, block.Hash, hash)
+		return c.getBlockInfoByNumber(ctx, blockNumber, false, "")
+	}
+	return block, "", nil
+}
+
 func (c *tezosConnector) getBlockInfoByHash(ctx context.Context, hashString string) (*rpc.Block, error) {
 	var blockInfo *rpc.Block
 	cached, ok := c.blockCache.Get(hashString)
@@ -1,17 +1,159 @@
 package tezos
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"math"
+	"math/big"
 
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-tezosconnect/internal/msgs"
 	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/trilitech/tzgo/codec"
+	"github.com/trilitech/tzgo/tezos"
 )
 
-// In Tezos, there is no concept of "gas price" as it exists in EVM chains.
-// Tezos uses a different mechanism for handling transaction costs and execution fees.
-// Instead of gas and gas prices, Tezos employs a concept known as "transaction fees."
-func (c *tezosConnector) GasPriceEstimate(_ context.Context, _ *ffcapi.GasPriceEstimateRequest) (*ffcapi.GasPriceEstimateResponse, ffcapi.ErrorReason, error) {
+// feeParams take the place of a gas price in Tezos. Rather than gas having a price, an operation must pay a fee
+// of at least the minimal fee, plus an amount for each byte of the operation and each unit of gas it is allowed
+// to consume, for a baker to include it in a block.
+type feeParams struct {
+	MinimalFee        int64 `json:"minimalFee"` // mutez
+	NanotezPerByte    int64 `json:"nanotezPerByte"`
+	NanotezPerGasUnit int64 `json:"nanotezPerGasUnit"`
+}
+
+// defaultFeeParams are the minimum fees that the mempool of a node accepts in its default configuration,
+// which are used when the configuration of the node cannot be read
+var defaultFeeParams = feeParams{
+	MinimalFee:        100,
+	NanotezPerByte:    1000,
+	NanotezPerGasUnit: 100,
+}
+
+// feeOverrides are the fee parameters set in config, which are used in place of those of the node
+type feeOverrides struct {
+	minimalFee        *int64
+	nanotezPerByte    *int64
+	nanotezPerGasUnit *int64
+}
+
+// mempoolFilter is the configuration of the node's mempool, which sets the minimum fees it accepts.
+// The per byte and per gas unit amounts are rationals, as a numerator and denominator.
+type mempoolFilter struct {
+	MinimalFees              int64    `json:"minimal_fees,string"`
+	MinimalNanotezPerGasUnit []string `json:"minimal_nanotez_per_gas_unit"`
+	MinimalNanotezPerByte    []string `json:"minimal_nanotez_per_byte"`
+}
+
+// GasPriceEstimate returns the fee parameters an operation must pay, from the mempool configuration of the node
+// unless overridden in config.
+func (c *tezosConnector) GasPriceEstimate(ctx context.Context, _ *ffcapi.GasPriceEstimateRequest) (*ffcapi.GasPriceEstimateResponse, ffcapi.ErrorReason, error) {
+	fp, err := c.getFeeParams(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	b, _ := json.Marshal(fp)
 	return &ffcapi.GasPriceEstimateResponse{
-		GasPrice: fftypes.JSONAnyPtr(`"0"`),
+		GasPrice: fftypes.JSONAnyPtrBytes(b),
 	}, "", nil
 }
+
+func (c *tezosConnector) getFeeParams(ctx context.Context) (*feeParams, error) {
+	o := c.feeOverrides
+	if o.minimalFee != nil && o.nanotezPerByte != nil && o.nanotezPerGasUnit != nil {
+		return &feeParams{
+			MinimalFee:        *o.minimalFee,
+			NanotezPerByte:    *o.nanotezPerByte,
+			NanotezPerGasUnit: *o.nanotezPerGasUnit,
+		}, nil
+	}
+
+	fp := &feeParams{}
+	var filter mempoolFilter
+	if err := c.client.Get(ctx, "chains/main/mempool/filter", &filter); err != nil {
+		log.L(ctx).Warnf("Failed to get the mempool filter of the node, using the default fees: %s", err)
+		*fp = defaultFeeParams
+	} else {
+		fp.MinimalFee = filter.MinimalFees
+		fp.NanotezPerByte = parseRationalCeil(filter.MinimalNanotezPerByte)
+		fp.NanotezPerGasUnit = parseRationalCeil(filter.MinimalNanotezPerGasUnit)
+	}
+	if o.minimalFee != nil {
+		fp.MinimalFee = *o.minimalFee
+	}
+	if o.nanotezPerByte != nil {
+		fp.NanotezPerByte = *o.nanotezPerByte
+	}
+	if o.nanotezPerGasUnit != nil {
+		fp.NanotezPerGasUnit = *o.nanotezPerGasUnit
+	}
+	return fp, nil
+}
+
+// parseRationalCeil rounds up a rational from the node, which is either a [numerator, denominator] pair or a single value
+func parseRationalCeil(r []string) int64 {
+	if len(r) == 0 {
+		return 0
+	}
+	num, ok := new(big.Int).SetString(r[0], 10)
+	if !ok {
+		return 0
+	}
+	den := big.NewInt(1)
+	if len(r) > 1 {
+		if d, ok := new(big.Int).SetString(r[1], 10); ok && d.Sign() > 0 {
+			den = d
+		}
+	}
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if m.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return q.Int64()
+}
+
+// parseGasPrice parses the fee parameters returned by GasPriceEstimate, when they are passed back on a send.
+// A gas price that is not an object, such as a fixed gas price set in the config of the transaction manager,
+// or that has no fee parameters set, returns nil so that the fee the operation was prepared with is kept.
+func parseGasPrice(ctx context.Context, gasPrice *fftypes.JSONAny) (*feeParams, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(gasPrice.Bytes()), []byte("{")) {
+		return nil, nil
+	}
+	var fp feeParams
+	if err := json.Unmarshal(gasPrice.Bytes(), &fp); err != nil {
+		return nil, i18n.NewError(ctx, msgs.MsgInvalidGasPrice, gasPrice.String(), err)
+	}
+	if fp == (feeParams{}) {
+		return nil, nil
+	}
+	return &fp, nil
+}
+
+// applyToOp sets the fee of each content of the operation to the minimum for its size and gas limit.
+// The size of the operation depends on the fee, so the fee is recalculated until it is stable.
+func (fp *feeParams) applyToOp(op *codec.Op) {
+	for i, v := range op.Contents {
+		limits := v.Limits()
+		limits.Fee = 0
+		lastFee := int64(-1)
+		for lastFee < limits.Fee {
+			lastFee = limits.Fee
+			limits.Fee = fp.minFee(v, limits.GasLimit, i == 0, op.Params)
+			v.WithLimits(limits)
+		}
+	}
+}
+
+func (fp *feeParams) minFee(o codec.Operation, gas int64, withHeader bool, p *tezos.Params) int64 {
+	buf := bytes.NewBuffer(nil)
+	_ = o.EncodeBuffer(buf, p)
+	size := int64(buf.Len())
+	if withHeader {
+		size += 32 + 64 // branch + signature
+	}
+	nanotez := float64(size*fp.NanotezPerByte + gas*fp.NanotezPerGasUnit)
+	return fp.MinimalFee + int64(math.Ceil(nanotez/1000))
+}
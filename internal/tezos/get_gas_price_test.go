@@ -1,19 +1,120 @@
 package tezos
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"testing"
 
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-tezosconnect/mocks/tzrpcbackendmocks"
 	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/trilitech/tzgo/codec"
+	"github.com/trilitech/tzgo/tezos"
 )
 
+func mockMempoolFilter(mRPC *tzrpcbackendmocks.RpcClient, filter string) *mock.Call {
+	return mRPC.On("Get", mock.Anything, "chains/main/mempool/filter", mock.Anything).
+		Run(func(args mock.Arguments) {
+			_ = json.Unmarshal([]byte(filter), args[2])
+		}).
+		Return(nil)
+}
+
 func TestGetGasPriceOK(t *testing.T) {
-	ctx, c, _, done := newTestConnector(t)
+	ctx, c, mRPC, done := newTestConnector(t)
 	defer done()
 
+	mockMempoolFilter(mRPC, `{"minimal_fees":"100","minimal_nanotez_per_gas_unit":["100","1"],"minimal_nanotez_per_byte":["2500","2"]}`)
+
 	var req ffcapi.GasPriceEstimateRequest
 	res, reason, err := c.GasPriceEstimate(ctx, &req)
 	assert.NoError(t, err)
 	assert.Empty(t, reason)
-	assert.Equal(t, `"0"`, res.GasPrice.String())
+	assert.JSONEq(t, `{"minimalFee":100,"nanotezPerByte":1250,"nanotezPerGasUnit":100}`, res.GasPrice.String())
+}
+
+func TestGetGasPriceConfigOverrides(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mockMempoolFilter(mRPC, `{"minimal_fees":"100","minimal_nanotez_per_gas_unit":["100","1"],"minimal_nanotez_per_byte":["1000","1"]}`).Once()
+
+	perGas := int64(0)
+	c.feeOverrides.nanotezPerGasUnit = &perGas
+	res, _, err := c.GasPriceEstimate(ctx, &ffcapi.GasPriceEstimateRequest{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"minimalFee":100,"nanotezPerByte":1000,"nanotezPerGasUnit":0}`, res.GasPrice.String())
+
+	// When everything is overridden, the node is not queried
+	minimalFee, perByte := int64(50), int64(500)
+	c.feeOverrides.minimalFee = &minimalFee
+	c.feeOverrides.nanotezPerByte = &perByte
+	res, _, err = c.GasPriceEstimate(ctx, &ffcapi.GasPriceEstimateRequest{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"minimalFee":50,"nanotezPerByte":500,"nanotezPerGasUnit":0}`, res.GasPrice.String())
+}
+
+func TestGetGasPriceNodeErrorUsesDefaults(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("Get", ctx, "chains/main/mempool/filter", mock.Anything).Return(errors.New("pop"))
+
+	res, _, err := c.GasPriceEstimate(ctx, &ffcapi.GasPriceEstimateRequest{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"minimalFee":100,"nanotezPerByte":1000,"nanotezPerGasUnit":100}`, res.GasPrice.String())
+
+	// the overrides still apply to the defaults
+	perGas := int64(0)
+	c.feeOverrides.nanotezPerGasUnit = &perGas
+	res, _, err = c.GasPriceEstimate(ctx, &ffcapi.GasPriceEstimateRequest{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"minimalFee":100,"nanotezPerByte":1000,"nanotezPerGasUnit":0}`, res.GasPrice.String())
+}
+
+func TestParseRationalCeil(t *testing.T) {
+	assert.Equal(t, int64(0), parseRationalCeil(nil))
+	assert.Equal(t, int64(0), parseRationalCeil([]string{"wrong"}))
+	assert.Equal(t, int64(100), parseRationalCeil([]string{"100"}))
+	assert.Equal(t, int64(34), parseRationalCeil([]string{"100", "3"}))
+	assert.Equal(t, int64(100), parseRationalCeil([]string{"100", "0"}))
+}
+
+func TestParseGasPriceKeepsPreparedFee(t *testing.T) {
+	for _, gasPrice := range []string{`"0"`, `0`, `"12345"`, `12345`, `{}`, `{"minimalFee":0}`} {
+		fp, err := parseGasPrice(context.Background(), fftypes.JSONAnyPtr(gasPrice))
+		assert.NoError(t, err)
+		assert.Nil(t, fp, gasPrice)
+	}
+}
+
+func TestParseGasPriceError(t *testing.T) {
+	_, err := parseGasPrice(context.Background(), fftypes.JSONAnyPtr(`{"minimalFee":"lots"}`))
+	assert.Regexp(t, "FF23017", err)
+}
+
+func TestFeeParamsApplyToOp(t *testing.T) {
+	op := codec.NewOp().WithParams(tezos.DefaultParams)
+	op.WithContents(&codec.Transaction{
+		Manager: codec.Manager{
+			Fee:      999999,
+			GasLimit: 1000,
+		},
+		Amount:      1,
+		Destination: tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"),
+	})
+
+	fp := &feeParams{MinimalFee: 100, NanotezPerByte: 1000, NanotezPerGasUnit: 100}
+	fp.applyToOp(op)
+	fee := op.Contents[0].Limits().Fee
+	assert.Equal(t, fp.minFee(op.Contents[0], 1000, true, op.Params), fee)
+	// The same as the default fee calculation of the Tezos client library
+	assert.Equal(t, codec.CalculateMinFee(op.Contents[0], 1000, true, op.Params), fee)
+
+	fp.NanotezPerGasUnit = 200
+	fp.applyToOp(op)
+	assert.Equal(t, fee+100, op.Contents[0].Limits().Fee)
 }
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
 	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-tezosconnect/internal/msgs"
 	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
 	"github.com/trilitech/tzgo/micheline"
 	"github.com/trilitech/tzgo/rpc"
@@ -14,7 +16,10 @@ import (
 
 const _address = "address"
 
+// receiptExtraInfo is the receipt of one of the contents of an operation group. Every manager operation has
+// the common fields, and the fields specific to its kind are omitted for other kinds.
 type receiptExtraInfo struct {
+	Kind                string            `json:"kind"`
 	ContractAddress     *tezos.Address    `json:"contractAddress"`
 	ConsumedGas         *fftypes.FFBigInt `json:"consumedGas"`
 	GasLimit            *fftypes.FFBigInt `json:"gasLimit"`
@@ -28,156 +33,255 @@ type receiptExtraInfo struct {
 	Status              *string           `json:"status"`
 	ErrorMessage        *string           `json:"errorMessage"`
 	Storage             *fftypes.JSONAny  `json:"storage"`
+	Amount              *fftypes.FFBigInt `json:"amount,omitempty"`
+	Entrypoint          string            `json:"entrypoint,omitempty"`
+	RevealedKey         *tezos.Key        `json:"revealedKey,omitempty"`
+	Delegate            *tezos.Address    `json:"delegate,omitempty"`
+	DepositsLimit       *fftypes.FFBigInt `json:"depositsLimit,omitempty"`
+	Ticketer            *tezos.Address    `json:"ticketer,omitempty"`
+	TicketType          *micheline.Prim   `json:"ticketType,omitempty"`
+	TicketContents      interface{}       `json:"ticketContents,omitempty"`
+	GlobalAddress       string            `json:"globalAddress,omitempty"`
+	Rollup              *tezos.Address    `json:"rollup,omitempty"`
+	PvmKind             string            `json:"pvmKind,omitempty"`
+	Commitment          string            `json:"commitment,omitempty"`
+	ConsensusKey        *tezos.Key        `json:"consensusKey,omitempty"`
+	BalanceUpdates      []*balanceChange  `json:"balanceUpdates,omitempty"`
+	BigMapDiffs         []*bigMapDiff     `json:"bigMapDiffs,omitempty"`
+	TicketUpdates       []*ticketUpdate   `json:"ticketUpdates,omitempty"`
+	// InternalOperations are the operations made by the contracts called by the content, and Events the events
+	// they emitted, so a receipt records everything that a contract call did
+	InternalOperations []*internalOperationReceipt `json:"internalOperations,omitempty"`
+	Events             []*eventReceipt             `json:"events,omitempty"`
 }
 
-// TransactionReceipt queries to see if a receipt is available for a given transaction hash
+// TransactionReceipt queries to see if a receipt is available for a given transaction hash.
+// It does not wait for the operation to be included, or confirmed, which is left to the confirmation manager.
 func (c *tezosConnector) TransactionReceipt(ctx context.Context, req *ffcapi.TransactionReceiptRequest) (*ffcapi.TransactionReceiptResponse, ffcapi.ErrorReason, error) {
-	opts := &rpc.DefaultOptions
-
-	// ensure block observer is running
-	rpcClient := c.client.(*rpc.Client)
-	rpcClient.Listen()
-
-	// wait for confirmations
-	res := rpc.NewResult(tezos.MustParseOpHash(req.TransactionHash)).WithTTL(opts.TTL).WithConfirmations(opts.Confirmations)
-	res.Listen(rpcClient.BlockObserver)
-	res.WaitContext(ctx)
-	if err := res.Err(); err != nil {
-		return nil, "", err
+	opHash, err := tezos.ParseOpHash(req.TransactionHash)
+	if err != nil {
+		return nil, ffcapi.ErrorReasonInvalidInputs, err
 	}
 
-	// return receipt
-	receipt, err := res.GetReceipt(ctx)
+	receipt, err := c.findOperation(ctx, opHash)
 	if err != nil {
 		return nil, "", err
 	}
-
-	blockNumber := receipt.Block.Int64()
-	block, _, err := c.BlockInfoByHash(ctx, &ffcapi.BlockInfoByHashRequest{
-		BlockHash: receipt.Block.String(),
-	})
-	if err != nil {
-		log.L(ctx).Error("error getting block: ", err)
-	} else {
-		blockNumber = block.BlockNumber.Int64()
+	if receipt == nil {
+		log.L(ctx).Debugf("Operation %s has not been included in a block", req.TransactionHash)
+		return nil, ffcapi.ErrorReasonNotFound, i18n.NewError(ctx, msgs.MsgReceiptNotAvailable, req.TransactionHash)
 	}
 
 	receiptResponse := &ffcapi.TransactionReceiptResponse{
 		TransactionReceiptResponseBase: ffcapi.TransactionReceiptResponseBase{
-			BlockNumber:      fftypes.NewFFBigInt(blockNumber),
+			BlockNumber:      fftypes.NewFFBigInt(receipt.Height),
 			TransactionIndex: fftypes.NewFFBigInt(int64(receipt.Pos)),
 			BlockHash:        receipt.Block.String(),
-			Success:          receipt.IsSuccess(),
+			Success:          operationSucceeded(receipt.Op),
 			ProtocolID:       receipt.Op.Protocol.String(),
 		},
 	}
 
-	if receipt.Op != nil {
-		var fullReceipt []byte
-		operationReceipts := make([]receiptExtraInfo, 0, len(receipt.Op.Contents))
-
-		for _, o := range receipt.Op.Contents {
-			if o.Kind() == tezos.OpTypeTransaction {
-				tx := o.(*rpc.Transaction)
-
-				txStatus := tx.Result().Status.String()
-				extraInfo := receiptExtraInfo{
-					ConsumedGas:         fftypes.NewFFBigInt(tx.Metadata.Result.ConsumedMilliGas / 1000),
-					GasLimit:            fftypes.NewFFBigInt(tx.GasLimit),
-					PaidStorageSizeDiff: fftypes.NewFFBigInt(tx.Metadata.Result.PaidStorageSizeDiff),
-					StorageSize:         fftypes.NewFFBigInt(tx.Metadata.Result.StorageSize),
-					StorageLimit:        fftypes.NewFFBigInt(tx.StorageLimit),
-					From:                &tx.Source,
-					To:                  &tx.Destination,
-					Counter:             fftypes.NewFFBigInt(tx.Counter),
-					Fee:                 fftypes.NewFFBigInt(tx.Fee),
-					Status:              &txStatus,
-				}
+	operationReceipts := make([]*receiptExtraInfo, 0, len(receipt.Op.Contents))
+	// a batch can make several calls to the same contract, so each script is only fetched once
+	scripts := make(map[string]*micheline.Script)
+	rb := c.newReceiptBuilder(receipt.Height)
 
-				var script *micheline.Script
-				if tx.Destination.IsContract() {
-					location, _ := json.Marshal(map[string]string{
-						_address: tx.Destination.String(),
-					})
-					receiptResponse.ContractLocation = fftypes.JSONAnyPtrBytes(location)
-					extraInfo.ContractAddress = &tx.Destination
-
-					script, err = c.client.GetContractScript(ctx, tx.Destination)
-					if err != nil {
-						log.L(ctx).Error("error getting contract script: ", err)
-					}
-				}
-				if len(tx.Result().Errors) > 0 {
-					errorMessage := ""
-					for _, err := range tx.Result().Errors {
-						errorMessage += err.Error()
-					}
-					extraInfo.ErrorMessage = &errorMessage
-				}
-				if prim := tx.Metadata.Result.Storage; prim != nil {
-					val := micheline.NewValue(script.StorageType(), *prim)
-					m, err := val.Map()
-					if err != nil {
-						log.L(ctx).Error("error parsing contract storage: ", err)
-					}
-					storageBytes, _ := json.Marshal(m)
-					extraInfo.Storage = fftypes.JSONAnyPtrBytes(storageBytes)
-				}
-
-				operationReceipts = append(operationReceipts, extraInfo)
-				fullReceipt, _ = json.Marshal(operationReceipts)
-			} else if o.Kind() == tezos.OpTypeOrigination {
-				result := o.(*rpc.Origination).Result()
-				originatedContracts := result.OriginatedContracts
-				if len(originatedContracts) > 0 {
-					location, _ := json.Marshal(map[string]string{
-						_address: originatedContracts[0].ContractAddress(),
-					})
-					receiptResponse.ContractLocation = fftypes.JSONAnyPtrBytes(location)
-				}
-				fullReceipt = c.extraInfoForDeployTransactionReceipt(ctx, result, operationReceipts)
-			}
+	for _, o := range receipt.Op.Contents {
+		extraInfo := c.buildContentReceipt(ctx, o, scripts)
+		if extraInfo.ContractAddress != nil {
+			location, _ := json.Marshal(map[string]string{
+				_address: extraInfo.ContractAddress.String(),
+			})
+			receiptResponse.ContractLocation = fftypes.JSONAnyPtrBytes(location)
 		}
-
-		receiptResponse.ExtraInfo = fftypes.JSONAnyPtrBytes(fullReceipt)
+		rb.addResultDetails(ctx, extraInfo, o)
+		operationReceipts = append(operationReceipts, extraInfo)
 	}
 
+	fullReceipt, _ := json.Marshal(operationReceipts)
+	receiptResponse.ExtraInfo = fftypes.JSONAnyPtrBytes(fullReceipt)
+
 	return receiptResponse, "", nil
 }
 
-func (c *tezosConnector) extraInfoForDeployTransactionReceipt(ctx context.Context, res rpc.OperationResult, operationReceipts []receiptExtraInfo) []byte {
-	status := res.Status.String()
-	extraInfo := receiptExtraInfo{
-		ConsumedGas:         fftypes.NewFFBigInt(res.ConsumedMilliGas / 1000),
-		StorageSize:         fftypes.NewFFBigInt(res.StorageSize),
-		PaidStorageSizeDiff: fftypes.NewFFBigInt(res.PaidStorageSizeDiff),
-		Status:              &status,
+// operationSucceeded checks every content of an operation was applied, as a failure of any of them fails the
+// whole operation, and the first content is a reveal for the first operation of an account
+func operationSucceeded(op *rpc.Operation) bool {
+	if op == nil {
+		return false
 	}
-
-	if len(res.Errors) > 0 {
-		errorMessage := ""
-		for _, err := range res.Errors {
-			errorMessage += err.Error()
+	for _, o := range op.Contents {
+		switch o.Result().Status {
+		case tezos.OpStatusApplied, tezos.OpStatusInvalid:
+			// only manager operations have a status
+		default:
+			return false
 		}
-		extraInfo.ErrorMessage = &errorMessage
+	}
+	return true
+}
+
+// buildContentReceipt builds the receipt of a content of an operation, with the fields common to all manager
+// operations and those specific to its kind. The contract address is set for a call to a contract, or the
+// origination of one, and the new storage of the contract is decoded using its storage type.
+func (c *tezosConnector) buildContentReceipt(ctx context.Context, o rpc.TypedOperation, scripts map[string]*micheline.Script) *receiptExtraInfo {
+	res := o.Result()
+	status := res.Status.String()
+	extraInfo := &receiptExtraInfo{
+		Kind:         o.Kind().String(),
+		ConsumedGas:  fftypes.NewFFBigInt(res.ConsumedMilliGas / 1000),
+		Status:       &status,
+		ErrorMessage: resultErrorMessage(res),
+	}
+	if m := managerOf(o); m != nil {
+		extraInfo.From = &m.Source
+		extraInfo.Counter = fftypes.NewFFBigInt(m.Counter)
+		extraInfo.Fee = fftypes.NewFFBigInt(m.Fee)
+		extraInfo.GasLimit = fftypes.NewFFBigInt(m.GasLimit)
+		extraInfo.StorageLimit = fftypes.NewFFBigInt(m.StorageLimit)
 	}
 
-	if prim := res.Storage; prim != nil {
-		val := micheline.NewValue(res.Storage.BuildType(), *prim)
-		m, err := val.Map()
-		if err != nil {
-			log.L(ctx).Error("error parsing contract storage: ", err)
+	switch op := o.(type) {
+	case *rpc.Reveal:
+		// the key of the source was revealed by this operation, as it was the first sent by the account
+		extraInfo.RevealedKey = &op.PublicKey
+	case *rpc.Transaction:
+		extraInfo.To = &op.Destination
+		extraInfo.Amount = fftypes.NewFFBigInt(op.Amount)
+		extraInfo.PaidStorageSizeDiff = fftypes.NewFFBigInt(res.PaidStorageSizeDiff)
+		extraInfo.StorageSize = fftypes.NewFFBigInt(res.StorageSize)
+		if op.Parameters != nil {
+			extraInfo.Entrypoint = op.Parameters.Entrypoint
+		}
+		if op.Destination.IsContract() {
+			extraInfo.ContractAddress = &op.Destination
+			script, ok := scripts[op.Destination.String()]
+			if !ok {
+				var err error
+				script, err = c.client.GetContractScript(ctx, op.Destination)
+				if err != nil {
+					log.L(ctx).Error("error getting contract script: ", err)
+				}
+				scripts[op.Destination.String()] = script
+			}
+			if res.Storage != nil && script != nil {
+				extraInfo.Storage = decodeStorage(ctx, script.StorageType(), *res.Storage)
+			}
+		}
+	case *rpc.Origination:
+		extraInfo.Amount = fftypes.NewFFBigInt(op.Balance)
+		extraInfo.Delegate = op.Delegate
+		extraInfo.PaidStorageSizeDiff = fftypes.NewFFBigInt(res.PaidStorageSizeDiff)
+		extraInfo.StorageSize = fftypes.NewFFBigInt(res.StorageSize)
+		if len(res.OriginatedContracts) > 0 {
+			extraInfo.ContractAddress = &res.OriginatedContracts[0]
+		}
+		if res.Storage != nil {
+			extraInfo.Storage = decodeStorage(ctx, res.Storage.BuildType(), *res.Storage)
 		}
-		storageBytes, _ := json.Marshal(m)
-		extraInfo.Storage = fftypes.JSONAnyPtrBytes(storageBytes)
+	case *rpc.Delegation:
+		// a delegation without a delegate withdraws the delegate of the source
+		if op.Delegate.IsValid() {
+			extraInfo.Delegate = &op.Delegate
+		}
+	case *rpc.SetDepositsLimit:
+		extraInfo.DepositsLimit = fftypes.NewFFBigInt(op.Limit)
+	case *rpc.IncreasePaidStorage:
+		extraInfo.To = &op.Destination
+		extraInfo.Amount = fftypes.NewFFBigInt(op.Amount)
+		extraInfo.PaidStorageSizeDiff = fftypes.NewFFBigInt(res.PaidStorageSizeDiff)
+	case *rpc.TransferTicket:
+		extraInfo.To = &op.Destination
+		extraInfo.Entrypoint = op.Entrypoint
+		extraInfo.Amount = (*fftypes.FFBigInt)(op.Amount.Big())
+		extraInfo.Ticketer = &op.Ticketer
+		extraInfo.TicketType = &op.Type
+		extraInfo.TicketContents = c.formatValue(ctx, op.Type, op.Contents)
+		extraInfo.PaidStorageSizeDiff = fftypes.NewFFBigInt(res.PaidStorageSizeDiff)
+	case *rpc.ConstantRegistration:
+		extraInfo.GlobalAddress = res.GlobalAddress.String()
+		extraInfo.StorageSize = fftypes.NewFFBigInt(res.StorageSize)
+	case *rpc.UpdateConsensusKey:
+		extraInfo.ConsensusKey = &op.Pk
+	case *rpc.SmartRollupOriginate:
+		extraInfo.Rollup = res.Address
+		extraInfo.PvmKind = op.PvmKind.String()
+	case *rpc.SmartRollupCement:
+		extraInfo.Rollup = &op.Rollup
+		if res.Commitment != nil {
+			extraInfo.Commitment = res.Commitment.String()
+		}
+	case *rpc.SmartRollupPublish:
+		extraInfo.Rollup = &op.Rollup
+		if res.StakedHash != nil {
+			extraInfo.Commitment = res.StakedHash.String()
+		}
+	case *rpc.SmartRollupRefute:
+		extraInfo.Rollup = &op.Rollup
+	case *rpc.SmartRollupTimeout:
+		extraInfo.Rollup = &op.Rollup
+	case *rpc.SmartRollupExecuteOutboxMessage:
+		extraInfo.Rollup = &op.Rollup
+		extraInfo.Commitment = op.CementedCommitment.String()
+	case *rpc.SmartRollupRecoverBond:
+		extraInfo.Rollup = &op.Rollup
 	}
+	return extraInfo
+}
 
-	if len(res.OriginatedContracts) > 0 {
-		extraInfo.ContractAddress = &res.OriginatedContracts[0]
+// managerOf returns the fields common to the manager operations, which are signed and paid for by the source
+// account, or nil for other operations
+func managerOf(o rpc.TypedOperation) *rpc.Manager {
+	switch op := o.(type) {
+	case *rpc.Reveal:
+		return &op.Manager
+	case *rpc.Transaction:
+		return &op.Manager
+	case *rpc.Origination:
+		return &op.Manager
+	case *rpc.Delegation:
+		return &op.Manager
+	case *rpc.SetDepositsLimit:
+		return &op.Manager
+	case *rpc.IncreasePaidStorage:
+		return &op.Manager
+	case *rpc.TransferTicket:
+		return &op.Manager
+	case *rpc.ConstantRegistration:
+		return &op.Manager
+	case *rpc.UpdateConsensusKey:
+		return &op.Manager
+	case *rpc.SmartRollupOriginate:
+		return &op.Manager
+	case *rpc.SmartRollupAddMessages:
+		return &op.Manager
+	case *rpc.SmartRollupCement:
+		return &op.Manager
+	case *rpc.SmartRollupPublish:
+		return &op.Manager
+	case *rpc.SmartRollupRefute:
+		return &op.Manager
+	case *rpc.SmartRollupTimeout:
+		return &op.Manager
+	case *rpc.SmartRollupExecuteOutboxMessage:
+		return &op.Manager
+	case *rpc.SmartRollupRecoverBond:
+		return &op.Manager
+	case *rpc.DalPublishCommitment:
+		return &op.Manager
+	case *rpc.TxRollup:
+		return &op.Manager
 	}
+	return nil
+}
 
-	operationReceipts = append(operationReceipts, extraInfo)
-	fullReceipt, _ := json.Marshal(operationReceipts)
-
-	return fullReceipt
+func decodeStorage(ctx context.Context, storageType micheline.Type, prim micheline.Prim) *fftypes.JSONAny {
+	val := micheline.NewValue(storageType, prim)
+	m, err := val.Map()
+	if err != nil {
+		log.L(ctx).Error("error parsing contract storage: ", err)
+	}
+	storageBytes, _ := json.Marshal(m)
+	return fftypes.JSONAnyPtrBytes(storageBytes)
 }
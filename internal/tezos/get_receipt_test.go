@@ -0,0 +1,252 @@
+package tezos
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/trilitech/tzgo/micheline"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+func withStatus(status tezos.OpStatus) rpc.Manager {
+	return rpc.Manager{
+		Generic: rpc.Generic{
+			Metadata: rpc.OperationMetadata{
+				Result: rpc.OperationResult{
+					Status: status,
+				},
+			},
+		},
+	}
+}
+
+func testOpHash(b byte) tezos.OpHash {
+	buf := make([]byte, 32)
+	buf[0] = b
+	return tezos.NewOpHash(buf)
+}
+
+func TestTransactionReceiptFromIndexedOperation(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	contract := tezos.MustParseAddress("KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s")
+	source := tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
+	reveal := &rpc.Reveal{
+		Manager:   withStatus(tezos.OpStatusApplied),
+		PublicKey: tezos.MustParseKey("edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh"),
+	}
+	reveal.OpKind = tezos.OpTypeReveal
+	reveal.Source = source
+	tx := &rpc.Transaction{
+		Manager:     withStatus(tezos.OpStatusApplied),
+		Destination: contract,
+	}
+	tx.OpKind = tezos.OpTypeTransaction
+	tx.Source = source
+	block := testBlockWithOps(12345,
+		&rpc.Operation{Hash: testOpHash(1)},
+		&rpc.Operation{Hash: testOpHash(2), Contents: rpc.OperationList{reveal, tx}},
+	)
+	c.addToBlockCache(block)
+	c.indexBlockOperations(block)
+
+	mRPC.On("GetContractScript", ctx, contract).Return(nil, errors.New("pop"))
+
+	res, reason, err := c.TransactionReceipt(ctx, &ffcapi.TransactionReceiptRequest{
+		TransactionHash: testOpHash(2).String(),
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+	assert.Equal(t, int64(12345), res.BlockNumber.Int64())
+	assert.Equal(t, int64(1), res.TransactionIndex.Int64())
+	assert.Equal(t, block.Hash.String(), res.BlockHash)
+	assert.True(t, res.Success)
+	assert.JSONEq(t, `{"address":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s"}`, res.ContractLocation.String())
+
+	var extraInfo []receiptExtraInfo
+	err = json.Unmarshal(res.ExtraInfo.Bytes(), &extraInfo)
+	assert.NoError(t, err)
+	assert.Len(t, extraInfo, 2)
+	assert.Equal(t, "edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh", extraInfo[0].RevealedKey.String())
+	assert.Nil(t, extraInfo[1].RevealedKey)
+	assert.Equal(t, contract.String(), extraInfo[1].To.String())
+}
+
+func TestTransactionReceiptNotFound(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	c.receiptsMaxScanDepth = 0
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1)})
+	c.addToBlockCache(block)
+	c.indexBlockOperations(block)
+
+	_, reason, err := c.TransactionReceipt(ctx, &ffcapi.TransactionReceiptRequest{
+		TransactionHash: testOpHash(2).String(),
+	})
+	assert.Regexp(t, "FF23012", err)
+	assert.Equal(t, ffcapi.ErrorReasonNotFound, reason)
+}
+
+func TestTransactionReceiptScanError(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(12345), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 12345).Return(nil, errors.New("pop"))
+
+	_, reason, err := c.TransactionReceipt(ctx, &ffcapi.TransactionReceiptRequest{
+		TransactionHash: testOpHash(1).String(),
+	})
+	assert.Regexp(t, "pop", err)
+	assert.Empty(t, reason)
+}
+
+func TestTransactionReceiptInvalidHash(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	_, reason, err := c.TransactionReceipt(ctx, &ffcapi.TransactionReceiptRequest{
+		TransactionHash: "wrong",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+}
+
+func TestOperationSucceeded(t *testing.T) {
+	assert.False(t, operationSucceeded(nil))
+
+	// A reveal is applied, but the transaction after it failed
+	assert.False(t, operationSucceeded(&rpc.Operation{
+		Contents: []rpc.TypedOperation{
+			&rpc.Reveal{Manager: withStatus(tezos.OpStatusApplied)},
+			&rpc.Transaction{Manager: withStatus(tezos.OpStatusFailed)},
+		},
+	}))
+
+	assert.True(t, operationSucceeded(&rpc.Operation{
+		Contents: []rpc.TypedOperation{
+			&rpc.Reveal{Manager: withStatus(tezos.OpStatusApplied)},
+			&rpc.Transaction{Manager: withStatus(tezos.OpStatusApplied)},
+		},
+	}))
+}
+
+func withManager(kind tezos.OpType, status tezos.OpStatus, source tezos.Address) rpc.Manager {
+	m := withStatus(status)
+	m.OpKind = kind
+	m.Source = source
+	m.Fee = 500
+	m.Counter = 7
+	m.GasLimit = 1000
+	m.StorageLimit = 100
+	m.Metadata.Result.ConsumedMilliGas = 950000
+	return m
+}
+
+func TestTransactionReceiptManagerOperationKinds(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	source := tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
+	baker := tezos.MustParseAddress("tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb")
+	contract := tezos.MustParseAddress("KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s")
+	rollup := tezos.NewAddress(tezos.AddressTypeSmartRollup, make([]byte, 20))
+	commitment := tezos.NewSmartRollupCommitHash(make([]byte, 32))
+	globalAddress := tezos.NewExprHash(make([]byte, 32))
+
+	delegation := &rpc.Delegation{Manager: withManager(tezos.OpTypeDelegation, tezos.OpStatusApplied, source), Delegate: baker}
+	withdrawal := &rpc.Delegation{Manager: withManager(tezos.OpTypeDelegation, tezos.OpStatusApplied, source)}
+	depositsLimit := &rpc.SetDepositsLimit{Manager: withManager(tezos.OpTypeSetDepositsLimit, tezos.OpStatusApplied, baker), Limit: 1000000}
+	depositsLimit.Metadata = depositsLimit.Manager.Metadata
+	increaseStorage := &rpc.IncreasePaidStorage{Manager: withManager(tezos.OpTypeIncreasePaidStorage, tezos.OpStatusApplied, source), Destination: contract, Amount: 10}
+	increaseStorage.Metadata.Result.PaidStorageSizeDiff = 10
+	transferTicket := &rpc.TransferTicket{
+		Manager:     withManager(tezos.OpTypeTransferTicket, tezos.OpStatusApplied, source),
+		Destination: contract,
+		Entrypoint:  "receive",
+		Type:        micheline.NewCode(micheline.T_STRING),
+		Contents:    micheline.NewString("ticket"),
+		Ticketer:    contract,
+		Amount:      tezos.NewZ(5),
+	}
+	constant := &rpc.ConstantRegistration{Manager: withManager(tezos.OpTypeRegisterConstant, tezos.OpStatusApplied, source), Value: micheline.NewInt64(1)}
+	constant.Metadata.Result.GlobalAddress = globalAddress
+	rollupOrigination := &rpc.SmartRollupOriginate{Manager: withManager(tezos.OpTypeSmartRollupOriginate, tezos.OpStatusApplied, source), PvmKind: tezos.PvmKindWasm200}
+	rollupOrigination.Metadata.Result.Address = &rollup
+	cement := &rpc.SmartRollupCement{Manager: withManager(tezos.OpTypeSmartRollupCement, tezos.OpStatusApplied, source), Rollup: rollup}
+	cement.Metadata.Result.Commitment = &commitment
+	addMessages := &rpc.SmartRollupAddMessages{Manager: withManager(tezos.OpTypeSmartRollupAddMessages, tezos.OpStatusApplied, source)}
+	origination := &rpc.Origination{Manager: withManager(tezos.OpTypeOrigination, tezos.OpStatusApplied, source), Balance: 25, Delegate: &baker}
+	origination.Metadata.Result.OriginatedContracts = []tezos.Address{contract}
+
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1), Contents: rpc.OperationList{
+		delegation, withdrawal, depositsLimit, increaseStorage, transferTicket, constant, rollupOrigination, cement, addMessages, origination,
+	}})
+	c.addToBlockCache(block)
+	c.indexBlockOperations(block)
+
+	res, _, err := c.TransactionReceipt(ctx, &ffcapi.TransactionReceiptRequest{
+		TransactionHash: testOpHash(1).String(),
+	})
+	assert.NoError(t, err)
+	assert.True(t, res.Success)
+	assert.JSONEq(t, `{"address":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s"}`, res.ContractLocation.String())
+
+	var extraInfo []receiptExtraInfo
+	err = json.Unmarshal(res.ExtraInfo.Bytes(), &extraInfo)
+	assert.NoError(t, err)
+	assert.Len(t, extraInfo, 10)
+
+	// every manager operation has the common fields
+	kinds := make([]string, len(extraInfo))
+	for i, e := range extraInfo {
+		kinds[i] = e.Kind
+		assert.Equal(t, "applied", *e.Status)
+		assert.Equal(t, int64(950), e.ConsumedGas.Int64())
+		assert.Equal(t, int64(500), e.Fee.Int64())
+		assert.Equal(t, int64(7), e.Counter.Int64())
+		assert.Equal(t, int64(1000), e.GasLimit.Int64())
+		assert.Equal(t, int64(100), e.StorageLimit.Int64())
+		assert.NotNil(t, e.From)
+	}
+	assert.Equal(t, []string{
+		"delegation", "delegation", "set_deposits_limit", "increase_paid_storage", "transfer_ticket",
+		"register_global_constant", "smart_rollup_originate", "smart_rollup_cement", "smart_rollup_add_messages", "origination",
+	}, kinds)
+
+	assert.Equal(t, baker.String(), extraInfo[0].Delegate.String())
+	assert.Nil(t, extraInfo[1].Delegate)
+	assert.Equal(t, baker.String(), extraInfo[2].From.String())
+	assert.Equal(t, int64(1000000), extraInfo[2].DepositsLimit.Int64())
+	assert.Equal(t, contract.String(), extraInfo[3].To.String())
+	assert.Equal(t, int64(10), extraInfo[3].Amount.Int64())
+	assert.Equal(t, int64(10), extraInfo[3].PaidStorageSizeDiff.Int64())
+	assert.Equal(t, contract.String(), extraInfo[4].To.String())
+	assert.Equal(t, "receive", extraInfo[4].Entrypoint)
+	assert.Equal(t, int64(5), extraInfo[4].Amount.Int64())
+	assert.Equal(t, contract.String(), extraInfo[4].Ticketer.String())
+	assert.Equal(t, "ticket", extraInfo[4].TicketContents)
+	assert.Equal(t, globalAddress.String(), extraInfo[5].GlobalAddress)
+	assert.Equal(t, rollup.String(), extraInfo[6].Rollup.String())
+	assert.Equal(t, "wasm_2_0_0", extraInfo[6].PvmKind)
+	assert.Equal(t, rollup.String(), extraInfo[7].Rollup.String())
+	assert.Equal(t, commitment.String(), extraInfo[7].Commitment)
+	assert.Nil(t, extraInfo[8].Rollup)
+	assert.Equal(t, contract.String(), extraInfo[9].ContractAddress.String())
+	assert.Equal(t, int64(25), extraInfo[9].Amount.Int64())
+	assert.Equal(t, baker.String(), extraInfo[9].Delegate.String())
+}
+
+func TestManagerOf(t *testing.T) {
+	assert.Nil(t, managerOf(&rpc.Endorsement{}))
+	reveal := &rpc.Reveal{}
+	assert.Equal(t, &reveal.Manager, managerOf(reveal))
+}
@@ -0,0 +1,112 @@
+package tezos
+
+import (
+	"context"
+
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+// operationLocation is the position of an operation in a block, as held in the transaction cache
+type operationLocation struct {
+	blockHash string
+	level     int64
+	pass      int
+	position  int
+}
+
+// indexBlockOperations records where each manager operation of a block is in the transaction cache,
+// so its receipt can be found with a single block lookup
+func (c *tezosConnector) indexBlockOperations(block *rpc.Block) {
+	if len(block.Operations) <= managerOperationsPass {
+		return
+	}
+	for position, op := range block.Operations[managerOperationsPass] {
+		c.txCache.Add(op.Hash.String(), &operationLocation{
+			blockHash: block.Hash.String(),
+			level:     block.GetLevel(),
+			pass:      managerOperationsPass,
+			position:  position,
+		})
+	}
+}
+
+// findOperation returns the receipt of an operation that has been included in a block, or nil if it has not. A receipt
+// that is returned always has its operation set. The operation is looked up in the transaction cache, and then in the
+// blocks back from the head of the chain up to the max scan depth, for an operation in a block that has not been indexed.
+func (c *tezosConnector) findOperation(ctx context.Context, opHash tezos.OpHash) (*rpc.Receipt, error) {
+	receipt, err := c.findIndexedOperation(ctx, opHash)
+	if receipt != nil || err != nil || c.receiptsMaxScanDepth <= 0 {
+		return receipt, err
+	}
+
+	head := c.blockListener.getHighestBlock(ctx)
+	for level := head; level >= 0 && level > head-c.receiptsMaxScanDepth; level-- {
+		block, reason, err := c.getCanonicalBlock(ctx, level)
+		if err != nil {
+			if reason == ffcapi.ErrorReasonNotFound {
+				continue
+			}
+			return nil, err
+		}
+		c.indexBlockOperations(block)
+		if receipt := operationInBlock(block, opHash); receipt != nil {
+			return receipt, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *tezosConnector) findIndexedOperation(ctx context.Context, opHash tezos.OpHash) (*rpc.Receipt, error) {
+	cached, ok := c.txCache.Get(opHash.String())
+	if !ok {
+		return nil, nil
+	}
+	loc := cached.(*operationLocation)
+
+	// the block the operation was indexed in might have been replaced by a re-org
+	if hash, ok := c.blockListener.getCanonicalBlockHash(loc.level); ok && hash != loc.blockHash {
+		log.L(ctx).Debugf("Operation %s was in block %d / %s, which is no longer in the canonical chain", opHash, loc.level, loc.blockHash)
+		c.txCache.Remove(opHash.String())
+		return nil, nil
+	}
+
+	block, err := c.getBlockInfoByHash(ctx, loc.blockHash)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	if len(block.Operations) <= loc.pass || len(block.Operations[loc.pass]) <= loc.position {
+		return nil, nil
+	}
+	op := block.Operations[loc.pass][loc.position]
+	if !op.Hash.Equal(opHash) {
+		return nil, nil
+	}
+	return &rpc.Receipt{
+		Block:  block.Hash,
+		Height: block.GetLevel(),
+		List:   loc.pass,
+		Pos:    loc.position,
+		Op:     op,
+	}, nil
+}
+
+func operationInBlock(block *rpc.Block, opHash tezos.OpHash) *rpc.Receipt {
+	if len(block.Operations) <= managerOperationsPass {
+		return nil
+	}
+	for position, op := range block.Operations[managerOperationsPass] {
+		if op.Hash.Equal(opHash) {
+			return &rpc.Receipt{
+				Block:  block.Hash,
+				Height: block.GetLevel(),
+				List:   managerOperationsPass,
+				Pos:    position,
+				Op:     op,
+			}
+		}
+	}
+	return nil
+}
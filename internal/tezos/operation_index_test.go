@@ -0,0 +1,168 @@
+package tezos
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+func TestIndexBlockOperations(t *testing.T) {
+	_, c, _, done := newTestConnector(t)
+	defer done()
+
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1)}, &rpc.Operation{Hash: testOpHash(2)})
+	c.indexBlockOperations(block)
+
+	cached, ok := c.txCache.Get(testOpHash(2).String())
+	assert.True(t, ok)
+	assert.Equal(t, &operationLocation{
+		blockHash: block.Hash.String(),
+		level:     12345,
+		pass:      managerOperationsPass,
+		position:  1,
+	}, cached)
+
+	// blocks without manager operations are ignored
+	c.indexBlockOperations(&rpc.Block{})
+	assert.Equal(t, 2, c.txCache.Len())
+}
+
+func TestFindIndexedOperationFetchesBlock(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1)})
+	c.indexBlockOperations(block)
+	mRPC.On("GetBlock", ctx, block.Hash).Return(block, nil).Once()
+
+	receipt, err := c.findOperation(ctx, testOpHash(1))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12345), receipt.Height)
+	assert.Equal(t, managerOperationsPass, receipt.List)
+	assert.Equal(t, 0, receipt.Pos)
+	assert.True(t, receipt.Block.Equal(block.Hash))
+
+	// the block is cached for the next lookup
+	receipt, err = c.findOperation(ctx, testOpHash(1))
+	assert.NoError(t, err)
+	assert.NotNil(t, receipt)
+}
+
+func TestFindIndexedOperationBlockError(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1)})
+	c.indexBlockOperations(block)
+	mRPC.On("GetBlock", ctx, block.Hash).Return(nil, errors.New("pop"))
+
+	_, err := c.findOperation(ctx, testOpHash(1))
+	assert.Regexp(t, "pop", err)
+}
+
+func TestFindIndexedOperationMismatch(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	c.receiptsMaxScanDepth = 0
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1)})
+	c.addToBlockCache(block)
+
+	// the location no longer matches the operation in the block
+	c.txCache.Add(testOpHash(2).String(), &operationLocation{blockHash: block.Hash.String(), level: 12345, pass: managerOperationsPass, position: 0})
+	c.txCache.Add(testOpHash(3).String(), &operationLocation{blockHash: block.Hash.String(), level: 12345, pass: managerOperationsPass, position: 1})
+
+	receipt, err := c.findOperation(ctx, testOpHash(2))
+	assert.NoError(t, err)
+	assert.Nil(t, receipt)
+
+	receipt, err = c.findOperation(ctx, testOpHash(3))
+	assert.NoError(t, err)
+	assert.Nil(t, receipt)
+}
+
+func TestFindIndexedOperationReplacedByReorg(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	c.receiptsMaxScanDepth = 0
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1)})
+	c.addToBlockCache(block)
+	c.indexBlockOperations(block)
+
+	// a different block is now canonical at the level of the operation
+	bl := c.blockListener
+	bl.canonicalChain.PushBack(&minimalBlockInfo{number: 12345, hash: tezos.NewBlockHash(make([]byte, 32)).String()})
+	bl.snapshotCanonicalChain()
+
+	receipt, err := c.findOperation(ctx, testOpHash(1))
+	assert.NoError(t, err)
+	assert.Nil(t, receipt)
+	assert.False(t, c.txCache.Contains(testOpHash(1).String()))
+}
+
+func TestFindOperationScansBackFromHead(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	c.receiptsMaxScanDepth = 3
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(100), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	mockBlockLevel(mRPC, 100).Return(testBlockWithOps(100), nil).Once()
+	mockBlockLevel(mRPC, 99).Return(nil, errors.New("status 404")).Once()
+	mockBlockLevel(mRPC, 98).Return(testBlockWithOps(98, &rpc.Operation{Hash: testOpHash(2)}, &rpc.Operation{Hash: testOpHash(1)}), nil).Once()
+
+	receipt, err := c.findOperation(ctx, testOpHash(1))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(98), receipt.Height)
+	assert.Equal(t, 1, receipt.Pos)
+
+	// the other operations of the scanned blocks were indexed
+	assert.True(t, c.txCache.Contains(testOpHash(2).String()))
+}
+
+func TestFindOperationScanDepthLimit(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	c.receiptsMaxScanDepth = 2
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(100), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	// only the head and the block before it are fetched
+	mockBlockLevel(mRPC, 100).Return(testBlockWithOps(100), nil).Once()
+	mockBlockLevel(mRPC, 99).Return(testBlockWithOps(99), nil).Once()
+
+	receipt, err := c.findOperation(ctx, testOpHash(1))
+	assert.NoError(t, err)
+	assert.Nil(t, receipt)
+}
+
+func TestFindOperationScanSkipsReplacedCachedBlock(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	c.receiptsMaxScanDepth = 2
+	mRPC.On("GetHeadBlock", mock.Anything).Return(testBlockWithOps(100), nil)
+	mRPC.On("MonitorBlockHeader", mock.Anything, mock.Anything).Return(nil).Maybe()
+	assert.Equal(t, int64(100), c.blockListener.getHighestBlock(ctx))
+
+	// the block cache holds a block at level 99 that the block listener has seen replaced in a re-org
+	forkedBlock := testBlockWithOps(99, &rpc.Operation{Hash: testOpHash(1)})
+	forkedBlock.Hash = tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL")
+	c.addToBlockCache(forkedBlock)
+	c.blockListener.mux.Lock()
+	c.blockListener.canonicalHashes[99] = testBlockWithOps(99).Hash.String()
+	c.blockListener.mux.Unlock()
+
+	mockBlockLevel(mRPC, 100).Return(testBlockWithOps(100), nil).Once()
+	mockBlockLevel(mRPC, 99).Return(testBlockWithOps(99), nil).Once()
+
+	receipt, err := c.findOperation(ctx, testOpHash(1))
+	assert.NoError(t, err)
+	assert.Nil(t, receipt)
+	assert.False(t, c.txCache.Contains(testOpHash(1).String()))
+}
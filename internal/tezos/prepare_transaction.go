@@ -1,12 +1,11 @@
 package tezos
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"strings"
 
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
@@ -23,29 +22,134 @@ import (
 
 // TransactionPrepare validates transaction inputs against the supplied schema/Michelson and performs any binary serialization required (prior to signing) to encode a transaction from JSON into the native blockchain format
 func (c *tezosConnector) TransactionPrepare(ctx context.Context, req *ffcapi.TransactionPrepareRequest) (res *ffcapi.TransactionPrepareResponse, reason ffcapi.ErrorReason, err error) {
-	params, err := c.prepareInputParams(ctx, &req.TransactionInput)
+	method, err := parseTransactionMethod(ctx, req.Method)
 	if err != nil {
 		return nil, ffcapi.ErrorReasonInvalidInputs, err
 	}
 
-	op, err := c.buildOp(ctx, params, req.From, req.To, req.Nonce)
+	limits, err := c.getTransactionLimits(ctx, method)
 	if err != nil {
-		return nil, "", err
+		return nil, ffcapi.ErrorReasonInvalidInputs, err
+	}
+
+	contents, err := c.buildTransactions(ctx, &req.TransactionInput, method)
+	if err != nil {
+		return nil, ffcapi.ErrorReasonInvalidInputs, err
 	}
 
-	opts := &rpc.DefaultOptions
-	if reason, err = c.estimateAndAssignTxCost(ctx, op, opts); err != nil {
+	op, err := c.buildOp(ctx, contents, req.From, req.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	if reason, err = c.estimateAndAssignTxCost(ctx, op, limits); err != nil {
 		return nil, reason, err
 	}
 	log.L(ctx).Infof("Prepared transaction method=%s dataLen=%d", req.Method.String(), len(op.Bytes()))
 
 	return &ffcapi.TransactionPrepareResponse{
 		Gas:             req.Gas,
-		TransactionData: hex.EncodeToString(op.Bytes()),
+		TransactionData: encodePreparedTransaction(op, limits),
 	}, "", nil
 }
 
-func (c *tezosConnector) estimateAndAssignTxCost(ctx context.Context, op *codec.Op, opts *rpc.CallOptions) (ffcapi.ErrorReason, error) {
+// preparedTransaction is the transaction data of a prepared operation, which is passed back on every send.
+// The limits the operation was prepared with are carried with it, so that the caps overridden in the options
+// of its method are also the caps it is checked against when it is sent.
+type preparedTransaction struct {
+	Operation string             `json:"operation"` // hex encoded, without the signature
+	Limits    *transactionLimits `json:"limits"`
+}
+
+func encodePreparedTransaction(op *codec.Op, limits *transactionLimits) string {
+	b, _ := json.Marshal(&preparedTransaction{
+		Operation: hex.EncodeToString(op.Bytes()),
+		Limits:    limits,
+	})
+	return string(b)
+}
+
+// decodePreparedTransaction decodes the operation and limits of a prepared transaction. Transaction data that is just
+// the hex encoded operation, as prepared by earlier versions of the connector, is checked against the caps in config.
+func (c *tezosConnector) decodePreparedTransaction(ctx context.Context, data string) (*codec.Op, *transactionLimits, error) {
+	prepared := &preparedTransaction{
+		Operation: data,
+	}
+	if strings.HasPrefix(strings.TrimSpace(data), "{") {
+		if err := json.Unmarshal([]byte(data), prepared); err != nil {
+			return nil, nil, i18n.NewError(ctx, msgs.MsgInvalidPreparedTx, err)
+		}
+	}
+	if prepared.Limits == nil {
+		limits := c.txLimits
+		prepared.Limits = &limits
+	}
+	opBytes, err := hex.DecodeString(prepared.Operation)
+	if err != nil {
+		return nil, nil, err
+	}
+	op, err := codec.DecodeOp(opBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return op, prepared.Limits, nil
+}
+
+// transactionMethod is the method of a transaction when it is a JSON object, rather than just the name of the
+// entrypoint. It can carry options for the transaction, and a batch of calls to make in a single operation.
+type transactionMethod struct {
+	Options *fftypes.JSONAny `json:"options,omitempty"`
+	Calls   []*contractCall  `json:"calls,omitempty"`
+}
+
+// contractCall is one of the calls of a batch, each of which is a transaction in the same operation group.
+// The destination defaults to the to address of the transaction, and the call is a plain transfer of tez if it
+// has neither an entrypoint nor params.
+type contractCall struct {
+	To         string            `json:"to,omitempty"`
+	Entrypoint string            `json:"entrypoint,omitempty"`
+	Params     *fftypes.JSONAny  `json:"params,omitempty"` // Michelson value of the entrypoint parameter
+	Amount     *fftypes.FFBigInt `json:"amount,omitempty"` // mutez
+}
+
+func parseTransactionMethod(ctx context.Context, method *fftypes.JSONAny) (*transactionMethod, error) {
+	var m transactionMethod
+	if method != nil && strings.HasPrefix(strings.TrimSpace(method.String()), "{") {
+		if err := json.Unmarshal(method.Bytes(), &m); err != nil {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidTransactionMethod, err)
+		}
+	}
+	return &m, nil
+}
+
+// transactionLimits cap the cost of a prepared operation, and set the safety margin added to its simulated limits.
+// They are set in config, and can be overridden for a single transaction in the "options" of its method.
+// A maximum of 0 means there is no cap.
+type transactionLimits struct {
+	MaxFee          int64 `json:"maxFee"`          // mutez
+	MaxGasLimit     int64 `json:"maxGasLimit"`     // gas units
+	MaxStorageLimit int64 `json:"maxStorageLimit"` // bytes
+	SafetyMargin    int64 `json:"safetyMargin"`    // gas units, and bytes of storage
+	// IgnoreLimits keeps the limits the operation was built with, rather than setting them from its simulation
+	IgnoreLimits bool `json:"-"`
+}
+
+// getTransactionLimits returns the limits from config, with any overrides in the options of the method applied
+func (c *tezosConnector) getTransactionLimits(ctx context.Context, method *transactionMethod) (*transactionLimits, error) {
+	limits := c.txLimits
+	if method.Options != nil {
+		d := json.NewDecoder(bytes.NewReader(method.Options.Bytes()))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&limits); err != nil {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidTransactionOptions, err)
+		}
+	}
+	if limits.MaxFee < 0 || limits.MaxGasLimit < 0 || limits.MaxStorageLimit < 0 || limits.SafetyMargin < 0 {
+		return nil, i18n.NewError(ctx, msgs.MsgInvalidTransactionOptions, "limits must not be negative")
+	}
+	return &limits, nil
+}
+
+func (c *tezosConnector) estimateAndAssignTxCost(ctx context.Context, op *codec.Op, limits *transactionLimits) (ffcapi.ErrorReason, error) {
 	// Simulate the transaction (dry run)
 	sim, reason, err := c.callTransaction(ctx, op, nil)
 	if err != nil {
@@ -53,46 +157,92 @@ func (c *tezosConnector) estimateAndAssignTxCost(ctx context.Context, op *codec.
 	}
 
 	// apply simulated cost as limits to tx list
-	if !opts.IgnoreLimits {
-		op.WithLimits(sim.MinLimits(), rpc.ExtraSafetyMargin)
+	if !limits.IgnoreLimits {
+		op.WithLimits(sim.MinLimits(), limits.SafetyMargin)
 	}
 
 	// log info about tx costs
 	costs := sim.Costs()
 	for i, v := range op.Contents {
 		verb := "used"
-		if opts.IgnoreLimits {
+		if limits.IgnoreLimits {
 			verb = "forced"
 		}
-		limits := v.Limits()
+		l := v.Limits()
 		log.L(ctx).Debugf("OP#%03d: %s gas_used(sim)=%d storage_used(sim)=%d storage_burn(sim)=%d alloc_burn(sim)=%d fee(%s)=%d gas_limit(%s)=%d storage_limit(%s)=%d ",
 			i, v.Kind(), costs[i].GasUsed, costs[i].StorageUsed, costs[i].StorageBurn, costs[i].AllocationBurn,
-			verb, limits.Fee, verb, limits.GasLimit, verb, limits.StorageLimit,
+			verb, l.Fee, verb, l.GasLimit, verb, l.StorageLimit,
 		)
 	}
 
-	// check minFee calc against maxFee if set
-	if opts.MaxFee > 0 {
-		if l := op.Limits(); l.Fee > opts.MaxFee {
-			return "", fmt.Errorf("estimated cost %d > max %d", l.Fee, opts.MaxFee)
-		}
+	// reject the operation rather than submitting it, if it costs more than allowed
+	if err := limits.check(ctx, op); err != nil {
+		return ffcapi.ErrorReasonInvalidInputs, err
 	}
 
 	return "", nil
 }
 
+// check returns an error if the total fee, gas limit or storage limit of the operation is over its cap
+func (limits *transactionLimits) check(ctx context.Context, op *codec.Op) error {
+	total := op.Limits()
+	for _, check := range []struct {
+		name       string
+		value, max int64
+	}{
+		{"fee", total.Fee, limits.MaxFee},
+		{"gas limit", total.GasLimit, limits.MaxGasLimit},
+		{"storage limit", total.StorageLimit, limits.MaxStorageLimit},
+	} {
+		if check.max > 0 && check.value > check.max {
+			return i18n.NewError(ctx, msgs.MsgOperationLimitExceedsMax, check.name, check.value, check.max)
+		}
+	}
+	return nil
+}
+
 func (c *tezosConnector) callTransaction(ctx context.Context, op *codec.Op, opts *rpc.CallOptions) (*rpc.Receipt, ffcapi.ErrorReason, error) {
 	sim, err := c.client.Simulate(ctx, op, opts)
 	if err != nil {
 		return nil, mapError(callRPCMethods, err), err
 	}
 	// fail with Tezos error when simulation failed
+	if errs := simulationErrors(sim); len(errs) > 0 {
+		for _, e := range errs {
+			if isInsufficientBalance(e.ID) {
+				account := op.Source.String()
+				if e.Contract != nil {
+					account = e.Contract.String()
+				}
+				return nil, ffcapi.ErrorReasonInsufficientFunds, i18n.NewError(ctx, msgs.MsgInsufficientBalance, account, e.ID)
+			}
+		}
+		err := errs[len(errs)-1].GenericError
+		return nil, mapError(callRPCMethods, err), err
+	}
 	if !sim.IsSuccess() {
 		return nil, mapError(callRPCMethods, sim.Error()), sim.Error()
 	}
 	return sim, "", nil
 }
 
+// simulationErrors returns the errors of the content or internal operation that failed the simulation.
+// Every content of a batch is checked, as any of them can fail.
+func simulationErrors(sim *rpc.Receipt) []rpc.OperationError {
+	for _, content := range sim.Op.Contents {
+		meta := content.Meta()
+		if res := meta.Result; res.Status != tezos.OpStatusApplied && len(res.Errors) > 0 {
+			return res.Errors
+		}
+		for _, ir := range meta.InternalResults {
+			if res := ir.Result; res.Status != tezos.OpStatusApplied && len(res.Errors) > 0 {
+				return res.Errors
+			}
+		}
+	}
+	return nil
+}
+
 func (c *tezosConnector) prepareInputParams(ctx context.Context, req *ffcapi.TransactionInput) (micheline.Parameters, error) {
 	var tezosParams micheline.Parameters
 
@@ -108,20 +258,136 @@ func (c *tezosConnector) prepareInputParams(ctx context.Context, req *ffcapi.Tra
 	return tezosParams, nil
 }
 
-func (c *tezosConnector) buildOp(ctx context.Context, params micheline.Parameters, fromString, toString string, nonce *fftypes.FFBigInt) (*codec.Op, error) {
-	op := codec.NewOp()
+// buildTransactions returns a transaction for each call of the batch in the method, or if there is no batch,
+// a single transaction to the to address with the params of the input
+func (c *tezosConnector) buildTransactions(ctx context.Context, tx *ffcapi.TransactionInput, method *transactionMethod) ([]codec.Operation, error) {
+	if len(method.Calls) == 0 {
+		params, err := c.prepareInputParams(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		toAddress, err := tezos.ParseAddress(tx.To)
+		if err != nil {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidToAddress, tx.To, err)
+		}
+		amount, err := parseAmount(ctx, tx.Value)
+		if err != nil {
+			return nil, err
+		}
+		txArgs := contract.TxArgs{}
+		txArgs.WithParameters(params)
+		txArgs.WithDestination(toAddress)
+		txArgs.WithAmount(amount)
+		content := txArgs.Encode()
+		if params.Entrypoint == "" && !params.Value.IsValid() {
+			// a plain transfer of tez, which does not call an entrypoint
+			content.Parameters = nil
+		}
+		return []codec.Operation{content}, nil
+	}
 
-	toAddress, err := tezos.ParseAddress(toString)
-	if err != nil {
-		return nil, i18n.NewError(ctx, msgs.MsgInvalidToAddress, toString, err)
+	if len(tx.Params) > 0 {
+		return nil, i18n.NewError(ctx, msgs.MsgBatchWithParams)
+	}
+	contents := make([]codec.Operation, len(method.Calls))
+	for i, call := range method.Calls {
+		content, err := call.toTransaction(tx.To)
+		if err != nil {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidBatchCall, i, err)
+		}
+		contents[i] = content
+	}
+	if err := checkAmount(ctx, tx.Value, contents); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// parseAmount parses the value of a transaction, which is the amount in mutez that it sends
+func parseAmount(ctx context.Context, value *fftypes.FFBigInt) (tezos.N, error) {
+	if value == nil {
+		return 0, nil
+	}
+	if value.Int().Sign() < 0 || !value.Int().IsInt64() {
+		return 0, i18n.NewError(ctx, msgs.MsgInvalidAmount, value.String())
+	}
+	return tezos.N(value.Int64()), nil
+}
+
+// sentAmount is the total amount in mutez that the contents of an operation send, to accounts and contracts
+// they call, or as the balance of contracts they originate
+func sentAmount(contents []codec.Operation) int64 {
+	var total int64
+	for _, content := range contents {
+		switch o := content.(type) {
+		case *codec.Transaction:
+			total += o.Amount.Int64()
+		case *codec.Origination:
+			total += o.Balance.Int64()
+		}
+	}
+	return total
+}
+
+// checkAmount checks that the value of a transaction, if it is set, is the total amount its operation sends
+func checkAmount(ctx context.Context, value *fftypes.FFBigInt, contents []codec.Operation) error {
+	if value == nil {
+		return nil
+	}
+	if total := sentAmount(contents); !value.Int().IsInt64() || value.Int64() != total {
+		return i18n.NewError(ctx, msgs.MsgAmountMismatch, value.String(), total)
+	}
+	return nil
+}
+
+func (call *contractCall) toTransaction(defaultTo string) (*codec.Transaction, error) {
+	if call == nil {
+		return nil, fmt.Errorf("missing call")
+	}
+	to := call.To
+	if to == "" {
+		to = defaultTo
+	}
+	toAddress, err := tezos.ParseAddress(to)
+	if err != nil || !toAddress.IsValid() {
+		return nil, fmt.Errorf("invalid to address '%s'", to)
+	}
+	content := &codec.Transaction{
+		Destination: toAddress,
+	}
+
+	if call.Amount != nil {
+		if call.Amount.Int().Sign() < 0 || !call.Amount.Int().IsInt64() {
+			return nil, fmt.Errorf("invalid amount %s", call.Amount.String())
+		}
+		content.Amount = tezos.N(call.Amount.Int64())
+	}
+
+	if call.Entrypoint != "" || call.Params != nil {
+		params := &micheline.Parameters{
+			Entrypoint: call.Entrypoint,
+			Value:      micheline.Unit,
+		}
+		if params.Entrypoint == "" {
+			params.Entrypoint = micheline.DEFAULT
+		}
+		if call.Params != nil {
+			if err := params.Value.UnmarshalJSON(call.Params.Bytes()); err != nil {
+				return nil, fmt.Errorf("invalid params: %s", err)
+			}
+		}
+		content.Parameters = params
 	}
+	return content, nil
+}
 
-	txArgs := contract.TxArgs{}
-	txArgs.WithParameters(params)
-	txArgs.WithDestination(toAddress)
-	op.WithContents(txArgs.Encode())
+// buildOp builds an operation group from the contents, from the source address.
+// Any reveal the source needs is added in front of the contents.
+func (c *tezosConnector) buildOp(ctx context.Context, contents []codec.Operation, fromString string, nonce *fftypes.FFBigInt) (*codec.Op, error) {
+	op := codec.NewOp()
+	op.Contents = contents
 
-	err = c.completeOp(ctx, op, fromString, nonce)
+	_, err := c.completeOp(ctx, op, fromString, nonce)
 	if err != nil {
 		return nil, err
 	}
@@ -129,10 +395,16 @@ func (c *tezosConnector) buildOp(ctx context.Context, params micheline.Parameter
 	return op, nil
 }
 
-func (c *tezosConnector) completeOp(ctx context.Context, op *codec.Op, fromString string, nonce *fftypes.FFBigInt) error {
+// completeOp checks the signer has the key of the source of an operation, sets the source, branch, chain params and
+// counters of the operation, and reveals the key of the source if it has not been revealed yet. It returns whether
+// a reveal was added, which has no limits or fee until the operation is simulated.
+func (c *tezosConnector) completeOp(ctx context.Context, op *codec.Op, fromString string, nonce *fftypes.FFBigInt) (bool, error) {
 	fromAddress, err := tezos.ParseAddress(fromString)
 	if err != nil {
-		return i18n.NewError(ctx, msgs.MsgInvalidFromAddress, fromString, err)
+		return false, i18n.NewError(ctx, msgs.MsgInvalidFromAddress, fromString, err)
+	}
+	if err := c.checkSignerAddress(ctx, fromAddress); err != nil {
+		return false, err
 	}
 	op.WithSource(fromAddress)
 
@@ -143,32 +415,38 @@ func (c *tezosConnector) completeOp(ctx context.Context, op *codec.Op, fromStrin
 
 	state, err := c.client.GetContractExt(ctx, fromAddress, rpc.Head)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	mayNeedReveal := len(op.Contents) > 0 && op.Contents[0].Kind() != tezos.OpTypeReveal
-	// add reveal if necessary
-	if mayNeedReveal && !state.IsRevealed() {
-		key, err := c.getPubKeyFromSignatory(ctx, op.Source.String())
+	revealAdded := false
+	hasReveal := len(op.Contents) > 0 && op.Contents[0].Kind() == tezos.OpTypeReveal
+	switch {
+	case len(op.Contents) > 0 && !hasReveal && !state.IsRevealed():
+		key, err := c.publicKey(ctx, op.Source)
 		if err != nil {
-			return err
+			return false, err
 		}
-
+		// the limits and fee of the reveal are set from the simulation of the whole operation
 		reveal := &codec.Reveal{
 			Manager: codec.Manager{
 				Source: fromAddress,
 			},
 			PublicKey: *key,
 		}
-		reveal.WithLimits(rpc.DefaultRevealLimits)
 		op.WithContentsFront(reveal)
+		revealAdded = true
+	case hasReveal && state.IsRevealed():
+		// another operation has revealed the key since this one was prepared, and a second reveal would fail
+		log.L(ctx).Infof("Removing the reveal from the operation of %s, as its key has already been revealed", fromAddress)
+		op.Contents = op.Contents[1:]
 	}
 
 	// assign nonce
 	nextCounter := nonce.Int64()
-	// Note: there are situations when a nonce becomes obsolete after assigning it to connector.NextNonceForSigner.
-	// In such cases, we update it with a more recent one.
-	if nextCounter != state.Counter+1 {
+	// The nonce assigned by the transaction manager is used, so that operations sent before earlier ones are included
+	// each have their own counter. A nonce can become obsolete after it was assigned, if the account has since used
+	// it outside of the connector, in which case the next counter of the account is used instead.
+	if nextCounter < state.Counter+1 {
 		nextCounter = state.Counter + 1
 	}
 	for _, op := range op.Contents {
@@ -176,7 +454,7 @@ func (c *tezosConnector) completeOp(ctx context.Context, op *codec.Op, fromStrin
 		nextCounter++
 	}
 
-	return nil
+	return revealAdded, nil
 }
 
 func getNetworkParamsByName(name string) *tezos.Params {
@@ -189,38 +467,3 @@ func getNetworkParamsByName(name string) *tezos.Params {
 		return tezos.DefaultParams
 	}
 }
-
-func (c *tezosConnector) getPubKeyFromSignatory(ctx context.Context, tezosAddress string) (*tezos.Key, error) {
-	url := c.signatoryURL + "/keys/" + tezosAddress
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("signatory resp with wrong status code %d", resp.StatusCode)
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	var pubKeyJSON struct {
-		PubKey string `json:"public_key"`
-	}
-	err = json.Unmarshal(body, &pubKeyJSON)
-	if err != nil {
-		return nil, err
-	}
-
-	key, err := tezos.ParseKey(pubKeyJSON.PubKey)
-	if err != nil {
-		return nil, err
-	}
-
-	return &key, nil
-}
@@ -12,6 +12,7 @@ import (
 	"github.com/trilitech/tzgo/rpc"
 	"github.com/trilitech/tzgo/tezos"
 	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-tezosconnect/mocks/tzrpcbackendmocks"
 	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -86,7 +87,7 @@ func TestTransactionPrepareWithRevealSuccess(t *testing.T) {
 		w.Write([]byte("{\"public_key\":\"edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh\"}"))
 	}))
 	defer svr.Close()
-	c.signatoryURL = svr.URL
+	c.signer = newTestSignatorySigner(svr.URL)
 
 	mRPC.On("Simulate", ctx, mock.Anything, mock.Anything).
 		Return(&rpc.Receipt{
@@ -318,11 +319,8 @@ func TestTransactionPrepareWrongSimulateStatusError(t *testing.T) {
 	assert.Equal(t, reason, ffcapi.ErrorReasonTransactionReverted)
 }
 
-func Test_estimateAndAssignTxCostIgnoreLimitsOk(t *testing.T) {
-	ctx, c, mRPC, done := newTestConnector(t)
-	defer done()
-
-	mRPC.On("Simulate", ctx, mock.Anything, mock.Anything).
+func mockSimulateTransaction(mRPC *tzrpcbackendmocks.RpcClient, milligas, storage int64) *mock.Call {
+	return mRPC.On("Simulate", mock.Anything, mock.Anything, mock.Anything).
 		Return(&rpc.Receipt{
 			Op: &rpc.Operation{
 				Contents: []rpc.TypedOperation{
@@ -331,7 +329,9 @@ func Test_estimateAndAssignTxCostIgnoreLimitsOk(t *testing.T) {
 							Generic: rpc.Generic{
 								Metadata: rpc.OperationMetadata{
 									Result: rpc.OperationResult{
-										Status: tezos.OpStatusApplied,
+										Status:              tezos.OpStatusApplied,
+										ConsumedMilliGas:    milligas,
+										PaidStorageSizeDiff: storage,
 									},
 								},
 							},
@@ -340,55 +340,234 @@ func Test_estimateAndAssignTxCostIgnoreLimitsOk(t *testing.T) {
 				},
 			},
 		}, nil)
+}
+
+func mockSimulateWithReveal(mRPC *tzrpcbackendmocks.RpcClient, revealMilligas, milligas int64) *mock.Call {
+	applied := func(milligas int64) rpc.Manager {
+		return rpc.Manager{
+			Generic: rpc.Generic{
+				Metadata: rpc.OperationMetadata{
+					Result: rpc.OperationResult{
+						Status:           tezos.OpStatusApplied,
+						ConsumedMilliGas: milligas,
+					},
+				},
+			},
+		}
+	}
+	return mRPC.On("Simulate", mock.Anything, mock.Anything, mock.Anything).
+		Return(&rpc.Receipt{
+			Op: &rpc.Operation{
+				Contents: []rpc.TypedOperation{
+					rpc.Reveal{Manager: applied(revealMilligas)},
+					rpc.Transaction{Manager: applied(milligas)},
+				},
+			},
+		}, nil)
+}
+
+func TestTransactionPrepareRevealSimulatedAndKeyCached(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL"), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{Counter: 10}, nil)
+	var simulated *codec.Op
+	mockSimulateWithReveal(mRPC, 170000, 1000000).Run(func(args mock.Arguments) {
+		simulated = args[1].(*codec.Op)
+		// the reveal is simulated without the fixed default limits
+		assert.Equal(t, tezos.OpTypeReveal, simulated.Contents[0].Kind())
+		assert.Zero(t, simulated.Contents[0].Limits().GasLimit)
+	})
+
+	keyLookups := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyLookups++
+		w.Write([]byte("{\"public_key\":\"edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh\"}"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	req := &ffcapi.TransactionPrepareRequest{
+		TransactionInput: ffcapi.TransactionInput{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+			},
+			Method: fftypes.JSONAnyPtr("\"pause\""),
+			Params: []*fftypes.JSONAny{
+				fftypes.JSONAnyPtr("{\"entrypoint\":\"pause\",\"value\":{\"prim\":\"True\"}}"),
+			},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		res, _, err := c.TransactionPrepare(ctx, req)
+		assert.NoError(t, err)
+
+		op, _, err := c.decodePreparedTransaction(ctx, res.TransactionData)
+		assert.NoError(t, err)
+		assert.Len(t, op.Contents, 2)
+		reveal := op.Contents[0].Limits()
+		assert.Equal(t, int64(170+100), reveal.GasLimit)
+		assert.Positive(t, reveal.Fee)
+		assert.Equal(t, int64(11), op.Contents[0].GetCounter())
+		assert.Equal(t, int64(12), op.Contents[1].GetCounter())
+	}
+	// the public key is only looked up from the signer once
+	assert.Equal(t, 1, keyLookups)
+}
+
+func Test_estimateAndAssignTxCostIgnoreLimitsOk(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
 
-	op := codec.NewOp()
+	mockSimulateTransaction(mRPC, 1000000, 200)
+
+	op := codec.NewOp().WithParams(tezos.DefaultParams)
 	txArgs := contract.TxArgs{}
 	op.WithContents(txArgs.Encode())
+	op.WithLimits([]tezos.Limits{{GasLimit: 5000, StorageLimit: 10}}, 0)
+
+	limits := c.txLimits
+	limits.IgnoreLimits = true
+	_, err := c.estimateAndAssignTxCost(ctx, op, &limits)
+	assert.NoError(t, err)
+	// the limits the operation was built with are kept, rather than those simulated
+	assert.Equal(t, int64(5000), op.Limits().GasLimit)
+	assert.Equal(t, int64(10), op.Limits().StorageLimit)
+}
+
+func Test_estimateAndAssignTxCostSafetyMargin(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
 
-	opts := &rpc.DefaultOptions
-	opts.IgnoreLimits = true
+	mockSimulateTransaction(mRPC, 1000000, 0)
 
-	_, err := c.estimateAndAssignTxCost(ctx, op, opts)
+	op := codec.NewOp().WithParams(tezos.DefaultParams)
+	txArgs := contract.TxArgs{}
+	op.WithContents(txArgs.Encode())
+
+	limits := c.txLimits
+	limits.SafetyMargin = 50
+	_, err := c.estimateAndAssignTxCost(ctx, op, &limits)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1050), op.Limits().GasLimit)
 }
 
 func Test_estimateAndAssignExceedMaxLimitError(t *testing.T) {
 	ctx, c, mRPC, done := newTestConnector(t)
 	defer done()
 
-	mRPC.On("Simulate", ctx, mock.Anything, mock.Anything).
-		Return(&rpc.Receipt{
-			Op: &rpc.Operation{
-				Contents: []rpc.TypedOperation{
-					rpc.Transaction{
-						Manager: rpc.Manager{
-							Generic: rpc.Generic{
-								Metadata: rpc.OperationMetadata{
-									Result: rpc.OperationResult{
-										Status: tezos.OpStatusApplied,
-									},
-								},
-							},
-						},
-					},
-				},
-			},
+	mockSimulateTransaction(mRPC, 1000000, 200)
+
+	newOp := func() *codec.Op {
+		op := codec.NewOp().WithParams(tezos.DefaultParams)
+		txArgs := contract.TxArgs{}
+		return op.WithContents(txArgs.Encode())
+	}
+
+	reason, err := c.estimateAndAssignTxCost(ctx, newOp(), &transactionLimits{MaxFee: 1})
+	assert.Regexp(t, "FF23055.*fee", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+
+	_, err = c.estimateAndAssignTxCost(ctx, newOp(), &transactionLimits{MaxGasLimit: 1000, SafetyMargin: 100})
+	assert.Regexp(t, "FF23055.*gas limit of 1,100.*1,000", err)
+
+	_, err = c.estimateAndAssignTxCost(ctx, newOp(), &transactionLimits{MaxStorageLimit: 199})
+	assert.Regexp(t, "FF23055.*storage limit of 200.*199", err)
+
+	_, err = c.estimateAndAssignTxCost(ctx, newOp(), &transactionLimits{MaxFee: 1000000, MaxGasLimit: 1100, MaxStorageLimit: 300, SafetyMargin: 100})
+	assert.NoError(t, err)
+}
+
+func TestGetTransactionLimits(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	getLimits := func(method *fftypes.JSONAny) (*transactionLimits, error) {
+		m, err := parseTransactionMethod(ctx, method)
+		if err != nil {
+			return nil, err
+		}
+		return c.getTransactionLimits(ctx, m)
+	}
+
+	// Defaults from config
+	limits, err := getLimits(fftypes.JSONAnyPtr(`"pause"`))
+	assert.NoError(t, err)
+	assert.Equal(t, transactionLimits{MaxFee: 1000000, SafetyMargin: 100}, *limits)
+
+	limits, err = getLimits(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, c.txLimits, *limits)
+
+	// Overridden in the options of the method
+	limits, err = getLimits(fftypes.JSONAnyPtr(`{"name":"pause","options":{"maxGasLimit":5000,"safetyMargin":0}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, transactionLimits{MaxFee: 1000000, MaxGasLimit: 5000}, *limits)
+
+	_, err = getLimits(fftypes.JSONAnyPtr(`{"options":{"maxGas":5000}}`))
+	assert.Regexp(t, "FF23054.*maxGas", err)
+
+	_, err = getLimits(fftypes.JSONAnyPtr(`{"options":{"maxFee":-1}}`))
+	assert.Regexp(t, "FF23054", err)
+
+	_, err = getLimits(fftypes.JSONAnyPtr(`{"options":"wrong"}`))
+	assert.Regexp(t, "FF23054", err)
+
+	_, err = getLimits(fftypes.JSONAnyPtr(`{wrong`))
+	assert.Regexp(t, "FF23056", err)
+}
+
+func TestTransactionPrepareExceedsMaxFeeOverride(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
 		}, nil)
+	mockSimulateTransaction(mRPC, 1000000, 0)
 
-	op := codec.NewOp()
-	txArgs := contract.TxArgs{}
-	op.WithContents(txArgs.Encode())
-	op.WithLimits([]tezos.Limits{
-		{
-			Fee: 100,
+	req := &ffcapi.TransactionPrepareRequest{
+		TransactionInput: ffcapi.TransactionInput{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+			},
+			Method: fftypes.JSONAnyPtr(`{"options":{"maxFee":10}}`),
+			Params: []*fftypes.JSONAny{
+				fftypes.JSONAnyPtr("{\"entrypoint\":\"pause\",\"value\":{\"prim\":\"True\"}}"),
+			},
 		},
-	}, 100)
+	}
+	res, reason, err := c.TransactionPrepare(ctx, req)
+	assert.Regexp(t, "FF23055.*fee", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+	assert.Nil(t, res)
+}
 
-	opts := &rpc.DefaultOptions
-	opts.MaxFee = 1
+func TestTransactionPrepareInvalidOptions(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
 
-	_, err := c.estimateAndAssignTxCost(ctx, op, opts)
-	assert.Error(t, err)
+	req := &ffcapi.TransactionPrepareRequest{
+		TransactionInput: ffcapi.TransactionInput{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+			},
+			Method: fftypes.JSONAnyPtr(`{"options":{"maxFee":"lots"}}`),
+		},
+	}
+	_, reason, err := c.TransactionPrepare(ctx, req)
+	assert.Regexp(t, "FF23054", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
 }
 
 func TestTransactionPrepareWithRevealEmptyServerError(t *testing.T) {
@@ -432,80 +611,263 @@ func Test_getNetworkParamsByName(t *testing.T) {
 	assert.Equal(t, params, tezos.DefaultParams)
 }
 
-func Test_getPubKeyFromSignatoryInvalidRespKeySuccess(t *testing.T) {
-	ctx, c, _, done := newTestConnector(t)
+func TestTransactionPrepareBatch(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
 	defer done()
 
-	// Set up http mocks
-	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("{\"public_key\":\"edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh\"}"))
-	}))
-	defer svr.Close()
-	c.signatoryURL = svr.URL
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
 
-	resp, err := c.getPubKeyFromSignatory(ctx, "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
-	assert.NotNil(t, resp)
-	assert.NoError(t, err)
-}
+	applied := rpc.Transaction{
+		Manager: rpc.Manager{
+			Generic: rpc.Generic{
+				Metadata: rpc.OperationMetadata{
+					Result: rpc.OperationResult{
+						Status:           tezos.OpStatusApplied,
+						ConsumedMilliGas: 1000000,
+					},
+				},
+			},
+		},
+	}
+	var simulated *codec.Op
+	mRPC.On("Simulate", ctx, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			simulated = args[1].(*codec.Op)
+		}).
+		Return(&rpc.Receipt{
+			Op: &rpc.Operation{
+				Contents: []rpc.TypedOperation{applied, applied, applied},
+			},
+		}, nil)
 
-func Test_getPubKeyFromSignatoryyNilContextError(t *testing.T) {
-	_, c, _, done := newTestConnector(t)
-	defer done()
+	req := &ffcapi.TransactionPrepareRequest{
+		TransactionInput: ffcapi.TransactionInput{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From:  "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				To:    "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+				Value: fftypes.NewFFBigInt(1500000), // the total amount of the calls
+			},
+			Method: fftypes.JSONAnyPtr(`{
+				"calls": [
+					{"entrypoint": "approve", "params": {"prim":"Pair","args":[{"string":"KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9"},{"int":"100"}]}},
+					{"to": "KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9", "entrypoint": "deposit", "params": {"int":"100"}},
+					{"to": "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb", "amount": "1500000"}
+				]
+			}`),
+		},
+	}
+	res, reason, err := c.TransactionPrepare(ctx, req)
+	assert.NoError(t, err)
+	assert.Empty(t, reason)
+	assert.NotEmpty(t, res.TransactionData)
 
-	_, err := c.getPubKeyFromSignatory(nil, "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
-	assert.Error(t, err)
+	// One operation group, with a content for each call, in order
+	assert.Len(t, simulated.Contents, 3)
+	for i, content := range simulated.Contents {
+		assert.Equal(t, int64(11+i), content.GetCounter())
+		assert.Equal(t, int64(1100), content.Limits().GasLimit)
+	}
+	approve := simulated.Contents[0].(*codec.Transaction)
+	assert.Equal(t, "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s", approve.Destination.String())
+	assert.Equal(t, "approve", approve.Parameters.Entrypoint)
+	deposit := simulated.Contents[1].(*codec.Transaction)
+	assert.Equal(t, "KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9", deposit.Destination.String())
+	assert.Equal(t, int64(100), deposit.Parameters.Value.Int.Int64())
+	transfer := simulated.Contents[2].(*codec.Transaction)
+	assert.Equal(t, "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb", transfer.Destination.String())
+	assert.Equal(t, int64(1500000), transfer.Amount.Int64())
+	assert.Nil(t, transfer.Parameters)
 }
 
-func Test_getPubKeyFromSignatoryHttpError(t *testing.T) {
+func TestTransactionPrepareBatchInvalidCalls(t *testing.T) {
 	ctx, c, _, done := newTestConnector(t)
 	defer done()
 
-	_, err := c.getPubKeyFromSignatory(ctx, "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
-	assert.Error(t, err)
+	prepare := func(method string, params ...*fftypes.JSONAny) (ffcapi.ErrorReason, error) {
+		_, reason, err := c.TransactionPrepare(ctx, &ffcapi.TransactionPrepareRequest{
+			TransactionInput: ffcapi.TransactionInput{
+				TransactionHeaders: ffcapi.TransactionHeaders{
+					From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				},
+				Method: fftypes.JSONAnyPtr(method),
+				Params: params,
+			},
+		})
+		return reason, err
+	}
+
+	reason, err := prepare(`{"calls":[{"entrypoint":"pause"}]}`)
+	assert.Regexp(t, "FF23057.*0.*invalid to address", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+
+	_, err = prepare(`{"calls":[{"to":"tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"},{"to":"tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb","amount":"-1"}]}`)
+	assert.Regexp(t, "FF23057.*1.*invalid amount -1", err)
+
+	_, err = prepare(`{"calls":[{"to":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s","params":{"prim":"Nope"}}]}`)
+	assert.Regexp(t, "FF23057.*invalid params", err)
+
+	_, err = prepare(`{"calls":[null]}`)
+	assert.Regexp(t, "FF23057.*missing call", err)
+
+	_, err = prepare(`{"calls":[{"to":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s"}]}`, fftypes.JSONAnyPtr(`{"entrypoint":"pause","value":{"prim":"True"}}`))
+	assert.Regexp(t, "FF23058", err)
+
+	_, err = prepare(`{"calls":"wrong"}`)
+	assert.Regexp(t, "FF23056", err)
 }
 
-func Test_getPubKeyFromSignatoryHttpWrongStatusError(t *testing.T) {
-	ctx, c, _, done := newTestConnector(t)
+func TestTransactionPrepareWithAmount(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
 	defer done()
 
-	// Set up http mocks
-	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(500)
-		w.Write([]byte("internal error"))
-	}))
-	defer svr.Close()
-	c.signatoryURL = svr.URL
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+	var simulated []*codec.Op
+	mockSimulateTransaction(mRPC, 1000000, 0).Run(func(args mock.Arguments) {
+		simulated = append(simulated, args[1].(*codec.Op))
+	})
 
-	_, err := c.getPubKeyFromSignatory(ctx, "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
-	assert.Error(t, err)
+	// A plain transfer of tez to an implicit account
+	_, _, err := c.TransactionPrepare(ctx, &ffcapi.TransactionPrepareRequest{
+		TransactionInput: ffcapi.TransactionInput{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From:  "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				To:    "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb",
+				Value: fftypes.NewFFBigInt(1500000),
+			},
+		},
+	})
+	assert.NoError(t, err)
+	transfer := simulated[0].Contents[0].(*codec.Transaction)
+	assert.Equal(t, int64(1500000), transfer.Amount.Int64())
+	assert.Nil(t, transfer.Parameters)
+
+	// A call of a payable entrypoint
+	_, _, err = c.TransactionPrepare(ctx, &ffcapi.TransactionPrepareRequest{
+		TransactionInput: ffcapi.TransactionInput{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From:  "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				To:    "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+				Value: fftypes.NewFFBigInt(2000),
+			},
+			Method: fftypes.JSONAnyPtr(`"deposit"`),
+			Params: []*fftypes.JSONAny{
+				fftypes.JSONAnyPtr(`{"entrypoint":"deposit","value":{"prim":"Unit"}}`),
+			},
+		},
+	})
+	assert.NoError(t, err)
+	call := simulated[1].Contents[0].(*codec.Transaction)
+	assert.Equal(t, int64(2000), call.Amount.Int64())
+	assert.Equal(t, "deposit", call.Parameters.Entrypoint)
 }
 
-func Test_getPubKeyFromSignatoryUnmarshalRespError(t *testing.T) {
+func TestTransactionPrepareInvalidAmount(t *testing.T) {
 	ctx, c, _, done := newTestConnector(t)
 	defer done()
 
-	// Set up http mocks
-	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write(nil)
-	}))
-	defer svr.Close()
-	c.signatoryURL = svr.URL
+	_, reason, err := c.TransactionPrepare(ctx, &ffcapi.TransactionPrepareRequest{
+		TransactionInput: ffcapi.TransactionInput{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From:  "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				To:    "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb",
+				Value: fftypes.NewFFBigInt(-1),
+			},
+		},
+	})
+	assert.Regexp(t, "FF23059", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
 
-	_, err := c.getPubKeyFromSignatory(ctx, "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
-	assert.Error(t, err)
+	_, reason, err = c.TransactionPrepare(ctx, &ffcapi.TransactionPrepareRequest{
+		TransactionInput: ffcapi.TransactionInput{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From:  "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				Value: fftypes.NewFFBigInt(100),
+			},
+			Method: fftypes.JSONAnyPtr(`{"calls":[{"to":"tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb","amount":"100"},{"to":"tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb","amount":"200"}]}`),
+		},
+	})
+	assert.Regexp(t, "FF23060.*100.*300", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
 }
 
-func Test_getPubKeyFromSignatoryInvalidRespKeyError(t *testing.T) {
-	ctx, c, _, done := newTestConnector(t)
+func TestTransactionPrepareBalanceTooLow(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
 	defer done()
 
-	// Set up http mocks
-	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("{\"public_key\":\"invalid\"}"))
-	}))
-	defer svr.Close()
-	c.signatoryURL = svr.URL
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+	source := tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
+	// Only the second transfer of the batch fails
+	mRPC.On("Simulate", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.Receipt{
+			Op: &rpc.Operation{
+				Contents: []rpc.TypedOperation{
+					rpc.Transaction{
+						Manager: rpc.Manager{
+							Generic: rpc.Generic{
+								Metadata: rpc.OperationMetadata{
+									Result: rpc.OperationResult{
+										Status: tezos.OpStatusBacktracked,
+									},
+								},
+							},
+						},
+					},
+					rpc.Transaction{
+						Manager: rpc.Manager{
+							Generic: rpc.Generic{
+								Metadata: rpc.OperationMetadata{
+									Result: rpc.OperationResult{
+										Status: tezos.OpStatusFailed,
+										Errors: []rpc.OperationError{
+											{
+												GenericError: rpc.GenericError{
+													ID:   "proto.019-PtParisB.contract.balance_too_low",
+													Kind: "temporary",
+												},
+												Contract: &source,
+											},
+											{
+												GenericError: rpc.GenericError{
+													ID:   "proto.019-PtParisB.tez.subtraction_underflow",
+													Kind: "temporary",
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}, nil)
 
-	_, err := c.getPubKeyFromSignatory(ctx, "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
-	assert.Error(t, err)
+	_, reason, err := c.TransactionPrepare(ctx, &ffcapi.TransactionPrepareRequest{
+		TransactionInput: ffcapi.TransactionInput{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+			},
+			Method: fftypes.JSONAnyPtr(`{"calls":[{"to":"tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb","amount":"100"},{"to":"tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb","amount":"99999999999"}]}`),
+		},
+	})
+	assert.Regexp(t, "FF23061.*tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN.*balance_too_low", err)
+	assert.Equal(t, ffcapi.ErrorReasonInsufficientFunds, reason)
 }
@@ -0,0 +1,188 @@
+package tezos
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/trilitech/tzgo/micheline"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+// internalOperationReceipt is the result of an operation made by a contract while a content of the operation group
+// was applied - a transaction, origination or event. The internal operations of a content are applied after it,
+// in the order of their nonce.
+type internalOperationReceipt struct {
+	Kind            string            `json:"kind"`
+	Nonce           int64             `json:"nonce"`
+	Source          string            `json:"source"`
+	Destination     string            `json:"destination,omitempty"`
+	Amount          string            `json:"amount,omitempty"`
+	Entrypoint      string            `json:"entrypoint,omitempty"`
+	Parameters      *micheline.Prim   `json:"parameters,omitempty"`
+	Tag             string            `json:"tag,omitempty"`
+	ContractAddress string            `json:"contractAddress,omitempty"`
+	Status          string            `json:"status"`
+	ConsumedGas     *fftypes.FFBigInt `json:"consumedGas"`
+	ErrorMessage    *string           `json:"errorMessage,omitempty"`
+	BalanceUpdates  []*balanceChange  `json:"balanceUpdates,omitempty"`
+	BigMapDiffs     []*bigMapDiff     `json:"bigMapDiffs,omitempty"`
+	TicketUpdates   []*ticketUpdate   `json:"ticketUpdates,omitempty"`
+}
+
+// eventReceipt is an event emitted by a contract, with the payload decoded using the type of the event
+type eventReceipt struct {
+	Emitter string      `json:"emitter"`
+	Tag     string      `json:"tag,omitempty"`
+	Nonce   int64       `json:"nonce"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// ticketUpdate is the change to the balances of the accounts holding a ticket, with its content decoded
+// using the content type of the ticket
+type ticketUpdate struct {
+	Ticketer    string                 `json:"ticketer"`
+	ContentType micheline.Prim         `json:"contentType"`
+	Content     interface{}            `json:"content"`
+	Updates     []*ticketBalanceChange `json:"updates"`
+}
+
+type ticketBalanceChange struct {
+	Account string `json:"account"`
+	Amount  string `json:"amount"`
+}
+
+// receiptBuilder decodes what the contents of an operation did, for its receipt. The types of the big_maps
+// changed by the operation are fetched once, as the contents of a batch often change the same big_maps.
+type receiptBuilder struct {
+	c           *tezosConnector
+	blockNumber int64
+	bigMapTypes map[int64]*bigMapTypes
+}
+
+func (c *tezosConnector) newReceiptBuilder(blockNumber int64) *receiptBuilder {
+	return &receiptBuilder{
+		c:           c,
+		blockNumber: blockNumber,
+		bigMapTypes: make(map[int64]*bigMapTypes),
+	}
+}
+
+// addResultDetails adds the balance updates, big_map diffs and ticket updates of a content to its receipt,
+// along with the internal operations it made and the events they emitted
+func (rb *receiptBuilder) addResultDetails(ctx context.Context, extraInfo *receiptExtraInfo, content rpc.TypedOperation) {
+	r := newContentResult(content)
+	extraInfo.BalanceUpdates = r.balanceUpdates()
+	extraInfo.BigMapDiffs = rb.buildBigMapDiffs(ctx, r.bigMapDiffs)
+	extraInfo.TicketUpdates = rb.buildTicketUpdates(ctx, r.result.TicketUpdates())
+
+	for _, ir := range content.Meta().InternalResults {
+		extraInfo.InternalOperations = append(extraInfo.InternalOperations, rb.buildInternalOperation(ctx, newInternalResult(r, ir)))
+		if ir.Kind == tezos.OpTypeEvent {
+			extraInfo.Events = append(extraInfo.Events, &eventReceipt{
+				Emitter: ir.Source.String(),
+				Tag:     ir.Tag,
+				Nonce:   ir.Nonce,
+				Payload: rb.c.formatValue(ctx, ir.Type, ir.Payload),
+			})
+		}
+	}
+}
+
+func (rb *receiptBuilder) buildInternalOperation(ctx context.Context, r *operationResult) *internalOperationReceipt {
+	ir := r.internal
+	op := &internalOperationReceipt{
+		Kind:           ir.Kind.String(),
+		Nonce:          ir.Nonce,
+		Source:         ir.Source.String(),
+		Tag:            ir.Tag,
+		Status:         ir.Result.Status.String(),
+		ConsumedGas:    fftypes.NewFFBigInt(ir.Result.ConsumedMilliGas / 1000),
+		ErrorMessage:   resultErrorMessage(ir.Result),
+		BalanceUpdates: r.balanceUpdates(),
+		BigMapDiffs:    rb.buildBigMapDiffs(ctx, r.bigMapDiffs),
+	}
+	switch ir.Kind {
+	case tezos.OpTypeTransaction:
+		op.Destination = r.destination.String()
+		op.Amount = strconv.FormatInt(r.amount, 10)
+		op.Entrypoint = r.entrypoint
+		if r.parameters != nil {
+			op.Parameters = &r.parameters.Value
+		}
+	case tezos.OpTypeOrigination:
+		op.Amount = strconv.FormatInt(ir.Balance, 10)
+		if r.destination.IsValid() {
+			op.ContractAddress = r.destination.String()
+		}
+	}
+	// internal results have their ticket updates outside of the result in some protocols
+	ticketUpdates := ir.Result.TicketUpdates()
+	if len(ticketUpdates) == 0 {
+		ticketUpdates = ir.TicketUpdates
+	}
+	op.TicketUpdates = rb.buildTicketUpdates(ctx, ticketUpdates)
+	return op
+}
+
+// buildBigMapDiffs decodes the changes to big_maps of a receipt. Unlike an event, a receipt is still returned if
+// the types of a big_map cannot be found, with the keys and values of its changes left as Micheline.
+func (rb *receiptBuilder) buildBigMapDiffs(ctx context.Context, events micheline.BigmapEvents) []*bigMapDiff {
+	diffs, _ := rb.c.buildBigMapDiffs(ctx, events, nil, rb.getBigMapTypes)
+	return diffs
+}
+
+// getBigMapTypes gets the key and value types of a big_map at the block of the receipt, fetching them once for
+// all the contents of the operation. It returns no types, rather than an error, if they cannot be fetched.
+func (rb *receiptBuilder) getBigMapTypes(ctx context.Context, id int64) (*bigMapTypes, error) {
+	if types, ok := rb.bigMapTypes[id]; ok {
+		return types, nil
+	}
+	var types *bigMapTypes
+	info, err := rb.c.client.GetBigmapInfo(ctx, id, rpc.BlockLevel(rb.blockNumber))
+	if err != nil {
+		log.L(ctx).Warnf("Failed to get the types of big_map %d: %s", id, err)
+	} else {
+		types = &bigMapTypes{key: info.KeyType, value: info.ValueType}
+	}
+	// a big_map whose types could not be found is not looked up again for this receipt
+	rb.bigMapTypes[id] = types
+	return types, nil
+}
+
+func (rb *receiptBuilder) buildTicketUpdates(ctx context.Context, updates []rpc.TicketUpdate) []*ticketUpdate {
+	if len(updates) == 0 {
+		return nil
+	}
+	ticketUpdates := make([]*ticketUpdate, 0, len(updates))
+	for _, u := range updates {
+		tu := &ticketUpdate{
+			Ticketer:    u.Ticket.Ticketer.String(),
+			ContentType: u.Ticket.Type,
+			Content:     rb.c.formatValue(ctx, u.Ticket.Type, u.Ticket.Content),
+			Updates:     make([]*ticketBalanceChange, 0, len(u.Updates)),
+		}
+		for _, b := range u.Updates {
+			tu.Updates = append(tu.Updates, &ticketBalanceChange{
+				Account: b.Account.String(),
+				Amount:  b.Amount.String(),
+			})
+		}
+		ticketUpdates = append(ticketUpdates, tu)
+	}
+	return ticketUpdates
+}
+
+// resultErrorMessage joins the errors of a failed operation result, or returns nil if it has none
+func resultErrorMessage(res rpc.OperationResult) *string {
+	if len(res.Errors) == 0 {
+		return nil
+	}
+	errorMessage := ""
+	for _, err := range res.Errors {
+		errorMessage += err.Error()
+	}
+	return &errorMessage
+}
@@ -0,0 +1,252 @@
+package tezos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/trilitech/tzgo/micheline"
+	"github.com/trilitech/tzgo/rpc"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+func TestTransactionReceiptResultDetails(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	source := tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
+	contract := tezos.MustParseAddress(testEventContract)
+	other := tezos.MustParseAddress("KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9")
+	originated := tezos.MustParseAddress("KT1D5jmrBD7bDa3jCpgzo32FMYmRDdK2ihka")
+	keyHash := tezos.NewExprHash(make([]byte, 32)).String()
+
+	tx := &rpc.Transaction{
+		Manager: rpc.Manager{
+			Generic: rpc.Generic{
+				OpKind: tezos.OpTypeTransaction,
+				Metadata: rpc.OperationMetadata{
+					BalanceUpdates: rpc.BalanceUpdates{
+						{Kind: rpc.CONTRACT, Contract: source, Change: -1000},
+						{Kind: "accumulator", Category: "block fees", Change: 1000},
+					},
+					Result: rpc.OperationResult{
+						Status: tezos.OpStatusApplied,
+						BalanceUpdates: rpc.BalanceUpdates{
+							{Kind: rpc.CONTRACT, Contract: source, Change: -500},
+							{Kind: rpc.CONTRACT, Contract: contract, Change: 500},
+						},
+						LazyStorageDiff: json.RawMessage(`[
+							{"kind":"big_map","id":"42","diff":{"action":"update","updates":[
+								{"key_hash":"` + keyHash + `","key":{"string":"alice"},"value":{"int":"10"}},
+								{"key_hash":"` + keyHash + `","key":{"string":"bob"},"value":{"int":"20"}}
+							]}},
+							{"kind":"big_map","id":"43","diff":{"action":"alloc","updates":[],"key_type":{"prim":"nat"},"value_type":{"prim":"string"}}}
+						]`),
+						TicketUpdatesCorrect: []rpc.TicketUpdate{{
+							Ticket: rpc.Ticket{
+								Ticketer: contract,
+								Type:     micheline.NewCode(micheline.T_STRING),
+								Content:  micheline.NewString("ticket"),
+							},
+							Updates: []rpc.TicketBalanceUpdate{{Account: source, Amount: tezos.NewZ(3)}},
+						}},
+					},
+					InternalResults: []*rpc.InternalResult{
+						{
+							Kind:        tezos.OpTypeTransaction,
+							Source:      contract,
+							Nonce:       0,
+							Destination: &other,
+							Amount:      200,
+							Parameters:  &micheline.Parameters{Entrypoint: "deposit", Value: micheline.NewInt64(5)},
+							Result: rpc.OperationResult{
+								Status:           tezos.OpStatusApplied,
+								ConsumedMilliGas: 2000,
+								BalanceUpdates: rpc.BalanceUpdates{
+									{Kind: rpc.CONTRACT, Contract: contract, Change: -200},
+									{Kind: rpc.CONTRACT, Contract: other, Change: 200},
+								},
+							},
+						},
+						{
+							Kind:    tezos.OpTypeOrigination,
+							Source:  contract,
+							Nonce:   1,
+							Balance: 100,
+							Result: rpc.OperationResult{
+								Status:              tezos.OpStatusApplied,
+								OriginatedContracts: []tezos.Address{originated},
+							},
+						},
+						testEventResult(testEventContract, "transfer", micheline.NewString("hello")),
+					},
+				},
+			},
+			Source:   source,
+			Fee:      1000,
+			GasLimit: 10000,
+		},
+		Destination: contract,
+		Amount:      500,
+	}
+	tx.Metadata.InternalResults[2].Nonce = 2
+	block := testBlockWithOps(12345, &rpc.Operation{Hash: testOpHash(1), Contents: rpc.OperationList{tx}})
+	c.addToBlockCache(block)
+	c.indexBlockOperations(block)
+
+	mRPC.On("GetContractScript", ctx, contract).Return(nil, errors.New("pop"))
+	// the types of the big_map are fetched once for the receipt
+	mRPC.On("GetBigmapInfo", ctx, int64(42), rpc.BlockLevel(12345)).Return(&rpc.BigmapInfo{
+		KeyType:   micheline.NewCode(micheline.T_STRING),
+		ValueType: micheline.NewCode(micheline.T_NAT),
+	}, nil).Once()
+
+	res, _, err := c.TransactionReceipt(ctx, &ffcapi.TransactionReceiptRequest{
+		TransactionHash: testOpHash(1).String(),
+	})
+	assert.NoError(t, err)
+	assert.True(t, res.Success)
+
+	var extraInfo []map[string]interface{}
+	err = json.Unmarshal(res.ExtraInfo.Bytes(), &extraInfo)
+	assert.NoError(t, err)
+	assert.Len(t, extraInfo, 1)
+	details, _ := json.Marshal(map[string]interface{}{
+		"balanceUpdates":     extraInfo[0]["balanceUpdates"],
+		"bigMapDiffs":        extraInfo[0]["bigMapDiffs"],
+		"ticketUpdates":      extraInfo[0]["ticketUpdates"],
+		"internalOperations": extraInfo[0]["internalOperations"],
+		"events":             extraInfo[0]["events"],
+	})
+	assert.JSONEq(t, `{
+		"balanceUpdates": [
+			{"address":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN","change":"-1000","reason":"fee","category":"block fees"},
+			{"address":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN","change":"-500","reason":"transfer","counterparty":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s"},
+			{"address":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s","change":"500","reason":"transfer","counterparty":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"}
+		],
+		"bigMapDiffs": [
+			{"bigMap":42,"action":"update","keyHash":"`+keyHash+`","key":"alice","value":"10"},
+			{"bigMap":42,"action":"update","keyHash":"`+keyHash+`","key":"bob","value":"20"},
+			{"bigMap":43,"action":"alloc","keyType":{"prim":"nat"},"valueType":{"prim":"string"}}
+		],
+		"ticketUpdates": [
+			{"ticketer":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s","contentType":{"prim":"string"},"content":"ticket","updates":[
+				{"account":"tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN","amount":"3"}
+			]}
+		],
+		"internalOperations": [
+			{
+				"kind":"transaction","nonce":0,"source":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+				"destination":"KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9","amount":"200","entrypoint":"deposit",
+				"parameters":{"int":"5"},"status":"applied","consumedGas":"2",
+				"balanceUpdates":[
+					{"address":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s","change":"-200","reason":"transfer","counterparty":"KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9"},
+					{"address":"KT1Hkg5qeNhfwpKW4fXvq7HGZB9z2EnmCCA9","change":"200","reason":"transfer","counterparty":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s"}
+				]
+			},
+			{
+				"kind":"origination","nonce":1,"source":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s","amount":"100",
+				"contractAddress":"KT1D5jmrBD7bDa3jCpgzo32FMYmRDdK2ihka","status":"applied","consumedGas":"0"
+			},
+			{
+				"kind":"event","nonce":2,"source":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s","tag":"transfer",
+				"status":"applied","consumedGas":"0"
+			}
+		],
+		"events": [
+			{"emitter":"KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s","tag":"transfer","nonce":2,"payload":"hello"}
+		]
+	}`, string(details))
+}
+
+func TestReceiptBigMapDiffsTypesError(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetBigmapInfo", ctx, int64(42), rpc.BlockLevel(12345)).Return(nil, errors.New("pop")).Once()
+
+	// the receipt is still built, with the changes left as Micheline, and the types are only looked up once
+	content := &rpc.Transaction{}
+	content.Metadata.Result.LazyStorageDiff = json.RawMessage(`[
+		{"kind":"big_map","id":"42","diff":{"action":"update","updates":[
+			{"key_hash":"` + tezos.NewExprHash(make([]byte, 32)).String() + `","key":{"string":"alice"},"value":{"int":"10"}},
+			{"key_hash":"` + tezos.NewExprHash(make([]byte, 32)).String() + `","key":{"string":"bob"}}
+		]}}
+	]`)
+	extraInfo := &receiptExtraInfo{}
+	c.newReceiptBuilder(12345).addResultDetails(ctx, extraInfo, content)
+	assert.Len(t, extraInfo.BigMapDiffs, 2)
+	assert.Equal(t, micheline.NewString("alice"), extraInfo.BigMapDiffs[0].Key)
+	assert.Equal(t, micheline.NewInt64(10), extraInfo.BigMapDiffs[0].Value)
+	assert.Equal(t, micheline.NewString("bob"), extraInfo.BigMapDiffs[1].Key)
+	mRPC.AssertExpectations(t)
+}
+
+func TestBuildBigMapDiffsWithoutLookup(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	getTypes := func(ctx context.Context, id int64) (*bigMapTypes, error) {
+		return nil, errors.New("unexpected lookup")
+	}
+	events := micheline.BigmapEvents{
+		// a temporary big_map allocated and updated in the same result
+		{Action: micheline.DiffActionAlloc, Id: -1, KeyType: micheline.NewCode(micheline.T_STRING), ValueType: micheline.NewCode(micheline.T_NAT)},
+		{Action: micheline.DiffActionUpdate, Id: -1, Key: micheline.NewString("alice"), Value: micheline.NewInt64(10)},
+		// a big_map removed as a whole has nothing to decode
+		{Action: micheline.DiffActionRemove, Id: 42},
+	}
+	diffs, err := c.buildBigMapDiffs(ctx, events, nil, getTypes)
+	assert.NoError(t, err)
+	assert.Len(t, diffs, 3)
+	assert.Equal(t, "alice", diffs[1].Key)
+	assert.Equal(t, tezos.NewZ(10), diffs[1].Value)
+	assert.Nil(t, diffs[2].Key)
+
+	// only the matching changes are included
+	diffs, err = c.buildBigMapDiffs(ctx, events, func(d micheline.BigmapEvent) bool { return d.Id == 42 }, getTypes)
+	assert.NoError(t, err)
+	assert.Len(t, diffs, 1)
+	assert.Equal(t, "remove", diffs[0].Action)
+
+	diffs, err = c.buildBigMapDiffs(ctx, nil, nil, getTypes)
+	assert.NoError(t, err)
+	assert.Empty(t, diffs)
+}
+
+func TestReceiptInternalTicketUpdates(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	content := newContentResult(&rpc.Transaction{})
+	ir := &rpc.InternalResult{
+		Kind:   tezos.OpTypeTransaction,
+		Source: tezos.MustParseAddress(testEventContract),
+		Result: rpc.OperationResult{
+			Status: tezos.OpStatusFailed,
+			Errors: []rpc.OperationError{{GenericError: rpc.GenericError{ID: "proto.alpha.michelson_v1.script_rejected", Kind: "temporary"}}},
+		},
+		TicketUpdates: []rpc.TicketUpdate{{
+			Ticket: rpc.Ticket{
+				Ticketer: tezos.MustParseAddress(testEventContract),
+				Type:     micheline.NewCode(micheline.T_NAT),
+				Content:  micheline.NewInt64(1),
+			},
+		}},
+	}
+
+	op := c.newReceiptBuilder(12345).buildInternalOperation(ctx, newInternalResult(content, ir))
+	assert.Equal(t, "failed", op.Status)
+	assert.Regexp(t, "script_rejected", *op.ErrorMessage)
+	assert.Empty(t, op.BalanceUpdates)
+	assert.Len(t, op.TicketUpdates, 1)
+	assert.Equal(t, tezos.NewZ(1), op.TicketUpdates[0].Content)
+	assert.Empty(t, op.TicketUpdates[0].Updates)
+}
+
+func TestResultErrorMessage(t *testing.T) {
+	assert.Nil(t, resultErrorMessage(rpc.OperationResult{}))
+}
@@ -1,40 +1,68 @@
 package tezos
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
-	"net/http"
+	"math"
+	"strconv"
 
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-tezosconnect/internal/msgs"
 	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
 	"github.com/trilitech/tzgo/codec"
-	"github.com/trilitech/tzgo/tezos"
 )
 
 // TransactionSend combines a previously prepared encoded transaction, with a current gas price, and submits it to the transaction pool of the blockchain for mining
 func (c *tezosConnector) TransactionSend(ctx context.Context, req *ffcapi.TransactionSendRequest) (*ffcapi.TransactionSendResponse, ffcapi.ErrorReason, error) {
-	opBytes, err := hex.DecodeString(req.TransactionData)
+	op, limits, err := c.decodePreparedTransaction(ctx, req.TransactionData)
 	if err != nil {
 		return nil, ffcapi.ErrorReasonInvalidInputs, err
 	}
 
-	op, err := codec.DecodeOp(opBytes)
-	if err != nil {
+	// the amount sent was set when the operation was prepared, so must be the same as the value of the transaction
+	if err = checkAmount(ctx, req.Value, op.Contents); err != nil {
 		return nil, ffcapi.ErrorReasonInvalidInputs, err
 	}
 
 	// auto-complete op with branch, source, nonce, chain params
-	err = c.completeOp(ctx, op, req.From, req.Nonce)
+	revealAdded, err := c.completeOp(ctx, op, req.From, req.Nonce)
 	if err != nil {
 		return nil, "", err
 	}
 
+	// a reveal that was not in the prepared operation must be simulated and priced with the rest of the operation
+	if revealAdded {
+		if reason, err := c.estimateAndAssignTxCost(ctx, op, limits); err != nil {
+			return nil, reason, err
+		}
+	}
+
+	// recompute the fee with the fee parameters that were returned by GasPriceEstimate
+	if !req.GasPrice.IsNil() {
+		fp, err := parseGasPrice(ctx, req.GasPrice)
+		if err != nil {
+			return nil, ffcapi.ErrorReasonInvalidInputs, err
+		}
+		if fp != nil {
+			fp.applyToOp(op)
+		}
+	}
+
+	// a resubmission of an operation that was not included must pay a higher fee to replace it in the mempool
+	sentKey := sentFeeKey(req)
+	if err = c.bumpFeeForReplacement(ctx, op, sentKey, limits.MaxFee); err != nil {
+		return nil, "", err
+	}
+
+	// the fee from the gas price, or raised for a replacement, must still be within the caps the operation was prepared with
+	if err = limits.check(ctx, op); err != nil {
+		return nil, ffcapi.ErrorReasonInvalidInputs, err
+	}
+
 	// sign tx
-	err = c.signTxRemotely(ctx, op)
+	err = c.signOp(ctx, op)
 	if err != nil {
 		return nil, "", err
 	}
@@ -44,50 +72,60 @@ func (c *tezosConnector) TransactionSend(ctx context.Context, req *ffcapi.Transa
 	if err != nil {
 		return nil, mapError(sendRPCMethods, err), err
 	}
+	c.recordSentFee(sentKey, op)
 
 	return &ffcapi.TransactionSendResponse{
 		TransactionHash: hash.String(),
 	}, "", nil
 }
 
-func (c *tezosConnector) signTxRemotely(ctx context.Context, op *codec.Op) error {
-	if op == nil {
-		return errors.New("operation is empty")
-	}
-	url := c.signatoryURL + "/keys/" + op.Source.String()
-	requestBody, _ := json.Marshal(hex.EncodeToString(op.WatermarkedBytes()))
+// sentFeeKey identifies a transaction by its signer, its nonce and the operation that was prepared for it, which
+// are the same each time the transaction is resubmitted. The counter of the operation cannot be used, as it is
+// set from the state of the account on every send, so a different transaction can be sent with the same counter.
+func sentFeeKey(req *ffcapi.TransactionSendRequest) string {
+	hash := sha256.Sum256([]byte(req.TransactionData))
+	return req.From + ":" + strconv.FormatInt(req.Nonce.Int64(), 10) + ":" + hex.EncodeToString(hash[:])
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return err
-	}
+// recordSentFee records the fee of an operation that has been broadcast, in case it needs replacing
+func (c *tezosConnector) recordSentFee(key string, op *codec.Op) {
+	c.sentFees.Add(key, op.Limits().Fee)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+// bumpFeeForReplacement raises the fee of a transaction that was previously broadcast, by the configured percentage
+// of the previous fee, so that the mempool accepts it as a replacement of the operation that was not included.
+// The fee is never raised above the max fee, and an error is returned if it cannot be raised at all.
+func (c *tezosConnector) bumpFeeForReplacement(ctx context.Context, op *codec.Op, key string, maxFee int64) error {
+	cached, ok := c.sentFees.Get(key)
+	if !ok || len(op.Contents) == 0 {
+		return nil
 	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("signatory resp with wrong status code %d", resp.StatusCode)
+	previousFee := cached.(int64)
+	fee := op.Limits().Fee
+	bumpedFee := int64(math.Ceil(float64(previousFee) * (100 + c.feeBumpPercent) / 100))
+	if fee >= bumpedFee {
+		return nil
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	var signatureJSON struct {
-		Signature string
-	}
-	err = json.Unmarshal(body, &signatureJSON)
-	if err != nil {
-		return err
+	if maxFee > 0 && bumpedFee > maxFee {
+		if maxFee <= previousFee {
+			return i18n.NewError(ctx, msgs.MsgReplacementFeeExceedsMax, bumpedFee, opDescription(op), maxFee)
+		}
+		bumpedFee = maxFee
 	}
+	log.L(ctx).Infof("Raising fee of operation %s from %d to %d to replace the operation previously sent with fee %d", opDescription(op), fee, bumpedFee, previousFee)
+	// the whole increase is added to the first content, as the mempool compares the fees of the whole operation
+	first := op.Contents[0]
+	limits := first.Limits()
+	limits.Fee += bumpedFee - fee
+	first.WithLimits(limits)
+	return nil
+}
 
-	var sig tezos.Signature
-	err = sig.UnmarshalText([]byte(signatureJSON.Signature))
-	if err != nil {
-		return err
+// opDescription describes an operation by its source and counter, which is how the mempool identifies it
+func opDescription(op *codec.Op) string {
+	counter := int64(0)
+	if len(op.Contents) > 0 {
+		counter = op.Contents[0].GetCounter()
 	}
-
-	op.WithSignature(sig)
-	return nil
+	return op.Source.String() + ":" + strconv.FormatInt(counter, 10)
 }
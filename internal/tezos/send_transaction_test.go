@@ -1,7 +1,9 @@
 package tezos
 
 import (
+	"encoding/hex"
 	"errors"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -9,6 +11,7 @@ import (
 	"github.com/trilitech/tzgo/codec"
 	"github.com/trilitech/tzgo/rpc"
 	"github.com/trilitech/tzgo/tezos"
+	"github.com/hyperledger/firefly-common/pkg/fftypes"
 	"github.com/hyperledger/firefly-transaction-manager/pkg/ffcapi"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -24,7 +27,7 @@ func TestTransactionSendSuccess(t *testing.T) {
 		w.Write([]byte("{\"signature\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
 	}))
 	defer svr.Close()
-	c.signatoryURL = svr.URL
+	c.signer = newTestSignatorySigner(svr.URL)
 
 	mRPC.On("GetBlockHash", ctx, mock.Anything).
 		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
@@ -50,6 +53,346 @@ func TestTransactionSendSuccess(t *testing.T) {
 	assert.NotNil(t, resp)
 }
 
+func TestTransactionSendWithGasPrice(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"signature\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+	mRPC.On("Broadcast", ctx, mock.MatchedBy(func(op *codec.Op) bool {
+		// 100 mutez minimal fee, plus 1 mutez per byte of the operation
+		return op.Contents[0].Limits().Fee > 100
+	})).Return(tezos.OpHash([]byte("oovD5cUigLGLT6kGDqsLMyF2sc3MLyfYhJWRymCPxUKEx3vtQ5v")), nil)
+
+	req := &ffcapi.TransactionSendRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+			To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+		},
+		GasPrice:        fftypes.JSONAnyPtr(`{"minimalFee":100,"nanotezPerByte":1000,"nanotezPerGasUnit":100}`),
+		TransactionData: "424d426559724d4a704c577271437337555463466155514365574271736a434c6c00889816a17ae688c971be1ad34bfe1990f8fa5e0f000b0000000130a980e6e41028da2cacfca4ddefea252d18bed900ffff05706175736500000002030a",
+	}
+	resp, _, err := c.TransactionSend(ctx, req)
+	assert.Nil(t, err)
+	assert.NotNil(t, resp)
+}
+
+func TestTransactionSendFixedGasPriceKeepsPreparedFee(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"signature\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	txData := "424d426559724d4a704c577271437337555463466155514365574271736a434c6c00889816a17ae688c971be1ad34bfe1990f8fa5e0f000b0000000130a980e6e41028da2cacfca4ddefea252d18bed900ffff05706175736500000002030a"
+	opBytes, _ := hex.DecodeString(txData)
+	prepared, err := codec.DecodeOp(opBytes)
+	assert.NoError(t, err)
+	preparedFee := prepared.Contents[0].Limits().Fee
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+	mRPC.On("Broadcast", ctx, mock.MatchedBy(func(op *codec.Op) bool {
+		return op.Contents[0].Limits().Fee == preparedFee
+	})).Return(tezos.OpHash([]byte("oovD5cUigLGLT6kGDqsLMyF2sc3MLyfYhJWRymCPxUKEx3vtQ5v")), nil).Times(2)
+
+	// the gas price of the baseline, and a fixed gas price from the config of the transaction manager
+	for _, gasPrice := range []string{`"0"`, `12345`} {
+		req := &ffcapi.TransactionSendRequest{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+			},
+			GasPrice:        fftypes.JSONAnyPtr(gasPrice),
+			TransactionData: txData,
+		}
+		c.sentFees.Purge()
+		resp, _, err := c.TransactionSend(ctx, req)
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+	}
+	mRPC.AssertExpectations(t)
+}
+
+func TestTransactionSendBadGasPrice(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+
+	req := &ffcapi.TransactionSendRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+			To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+		},
+		GasPrice:        fftypes.JSONAnyPtr(`{"minimalFee":"lots"}`),
+		TransactionData: "424d426559724d4a704c577271437337555463466155514365574271736a434c6c00889816a17ae688c971be1ad34bfe1990f8fa5e0f000b0000000130a980e6e41028da2cacfca4ddefea252d18bed900ffff05706175736500000002030a",
+	}
+	resp, reason, err := c.TransactionSend(ctx, req)
+	assert.Regexp(t, "FF23017", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+	assert.Nil(t, resp)
+}
+
+func TestTransactionSendGasPriceExceedsMax(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+
+	req := &ffcapi.TransactionSendRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+			To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+		},
+		GasPrice:        fftypes.JSONAnyPtr(`{"minimalFee":5000,"nanotezPerByte":1000,"nanotezPerGasUnit":100}`),
+		TransactionData: "424d426559724d4a704c577271437337555463466155514365574271736a434c6c00889816a17ae688c971be1ad34bfe1990f8fa5e0f000b0000000130a980e6e41028da2cacfca4ddefea252d18bed900ffff05706175736500000002030a",
+	}
+	// The operation is not broadcast with a fee over the max fee
+	c.txLimits.MaxFee = 5000
+	resp, reason, err := c.TransactionSend(ctx, req)
+	assert.Regexp(t, "FF23055.*fee", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+	assert.Nil(t, resp)
+
+	// The other caps are checked again too
+	opBytes, _ := hex.DecodeString(req.TransactionData)
+	op, err := codec.DecodeOp(opBytes)
+	assert.NoError(t, err)
+	op.Contents[0].WithLimits(tezos.Limits{GasLimit: 1000})
+	req.TransactionData = hex.EncodeToString(op.Bytes())
+	c.txLimits.MaxFee = 0
+	c.txLimits.MaxGasLimit = 500
+	_, reason, err = c.TransactionSend(ctx, req)
+	assert.Regexp(t, "FF23055.*gas limit", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+	mRPC.AssertNotCalled(t, "Broadcast", mock.Anything, mock.Anything)
+}
+
+func TestTransactionSendWithPreparedLimits(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"signature\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+	mRPC.On("Broadcast", ctx, mock.Anything).
+		Run(func(args mock.Arguments) {
+			op := args[1].(*codec.Op)
+			assert.Greater(t, op.Limits().Fee, int64(5000))
+		}).
+		Return(tezos.OpHash([]byte("oovD5cUigLGLT6kGDqsLMyF2sc3MLyfYhJWRymCPxUKEx3vtQ5v")), nil)
+
+	// The operation is prepared with a max fee that overrides the lower cap in config
+	c.txLimits.MaxFee = 1000
+	limits, err := c.getTransactionLimits(ctx, &transactionMethod{
+		Options: fftypes.JSONAnyPtr(`{"maxFee":10000}`),
+	})
+	assert.NoError(t, err)
+	opBytes, _ := hex.DecodeString("424d426559724d4a704c577271437337555463466155514365574271736a434c6c00889816a17ae688c971be1ad34bfe1990f8fa5e0f000b0000000130a980e6e41028da2cacfca4ddefea252d18bed900ffff05706175736500000002030a")
+	op, err := codec.DecodeOp(opBytes)
+	assert.NoError(t, err)
+
+	req := &ffcapi.TransactionSendRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+			To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+		},
+		GasPrice:        fftypes.JSONAnyPtr(`{"minimalFee":5000,"nanotezPerByte":1000,"nanotezPerGasUnit":100}`),
+		TransactionData: encodePreparedTransaction(op, limits),
+	}
+	resp, _, err := c.TransactionSend(ctx, req)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	// The cap it was prepared with still applies
+	limits.MaxFee = 4000
+	req.TransactionData = encodePreparedTransaction(op, limits)
+	_, reason, err := c.TransactionSend(ctx, req)
+	assert.Regexp(t, "FF23055.*fee", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+	mRPC.AssertNumberOfCalls(t, "Broadcast", 1)
+}
+
+func TestTransactionSendInvalidPreparedData(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	req := &ffcapi.TransactionSendRequest{
+		TransactionData: `{"operation":false}`,
+	}
+	resp, reason, err := c.TransactionSend(ctx, req)
+	assert.Regexp(t, "FF23073", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
+	assert.Nil(t, resp)
+}
+
+func TestTransactionSendResubmitBumpsFee(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"signature\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	// The counter has not moved on, as the first operation was not included
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+	var fees []int64
+	mRPC.On("Broadcast", ctx, mock.Anything).
+		Run(func(args mock.Arguments) {
+			op := args[1].(*codec.Op)
+			assert.Equal(t, int64(11), op.Contents[0].GetCounter())
+			fees = append(fees, op.Limits().Fee)
+		}).
+		Return(tezos.OpHash([]byte("oovD5cUigLGLT6kGDqsLMyF2sc3MLyfYhJWRymCPxUKEx3vtQ5v")), nil)
+
+	req := &ffcapi.TransactionSendRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+			To:   "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+		},
+		GasPrice:        fftypes.JSONAnyPtr(`{"minimalFee":1000,"nanotezPerByte":1000,"nanotezPerGasUnit":100}`),
+		TransactionData: "424d426559724d4a704c577271437337555463466155514365574271736a434c6c00889816a17ae688c971be1ad34bfe1990f8fa5e0f000b0000000130a980e6e41028da2cacfca4ddefea252d18bed900ffff05706175736500000002030a",
+	}
+	for i := 0; i < 3; i++ {
+		_, _, err := c.TransactionSend(ctx, req)
+		assert.NoError(t, err)
+	}
+	// Each resubmission pays 10% more than the last
+	assert.Len(t, fees, 3)
+	assert.Equal(t, int64(math.Ceil(float64(fees[0])*1.1)), fees[1])
+	assert.Equal(t, int64(math.Ceil(float64(fees[1])*1.1)), fees[2])
+
+	// Once the max fee is reached, the operation cannot be replaced
+	c.txLimits.MaxFee = fees[2] + 1
+	_, _, err := c.TransactionSend(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, c.txLimits.MaxFee, fees[3])
+	_, _, err = c.TransactionSend(ctx, req)
+	assert.Regexp(t, "FF23053", err)
+	assert.Len(t, fees, 4)
+}
+
+func TestTransactionSendUsesAssignedNonce(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
+	defer done()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"signature\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
+	// Neither operation has been included yet
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 10,
+			Manager: "edpkv89Jj4aVWetK69CWm5ss1LayvK8dQoiFz7p995y1k3E8CZwqJ6",
+		}, nil)
+	var counters, fees []int64
+	mRPC.On("Broadcast", ctx, mock.Anything).
+		Run(func(args mock.Arguments) {
+			op := args[1].(*codec.Op)
+			counters = append(counters, op.Contents[0].GetCounter())
+			fees = append(fees, op.Limits().Fee)
+		}).
+		Return(tezos.OpHash([]byte("oovD5cUigLGLT6kGDqsLMyF2sc3MLyfYhJWRymCPxUKEx3vtQ5v")), nil)
+
+	txData := "424d426559724d4a704c577271437337555463466155514365574271736a434c6c00889816a17ae688c971be1ad34bfe1990f8fa5e0f000b0000000130a980e6e41028da2cacfca4ddefea252d18bed900ffff05706175736500000002030a"
+	opBytes, _ := hex.DecodeString(txData)
+	other, err := codec.DecodeOp(opBytes)
+	assert.NoError(t, err)
+	other.Contents[0].(*codec.Transaction).Parameters.Entrypoint = "resume"
+	gasPrice := fftypes.JSONAnyPtr(`{"minimalFee":1000,"nanotezPerByte":1000,"nanotezPerGasUnit":100}`)
+
+	// A nonce that the account has already used is replaced by the next counter of the account
+	for i, data := range []string{txData, hex.EncodeToString(other.Bytes()), txData} {
+		_, _, err := c.TransactionSend(ctx, &ffcapi.TransactionSendRequest{
+			TransactionHeaders: ffcapi.TransactionHeaders{
+				From:  "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+				To:    "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+				Nonce: fftypes.NewFFBigInt([]int64{11, 12, 5}[i]),
+			},
+			GasPrice:        gasPrice,
+			TransactionData: data,
+		})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []int64{11, 12, 11}, counters)
+	// The second operation is not a replacement of the first, so pays the fee for its own size
+	assert.Less(t, fees[1], int64(math.Ceil(float64(fees[0])*1.1)))
+}
+
+func TestBumpFeeForReplacementNotNeeded(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	op := codec.NewOp().WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+	op.WithContents(&codec.Transaction{
+		Manager: codec.Manager{Fee: 1000, Counter: 11},
+	})
+	key := sentFeeKey(&ffcapi.TransactionSendRequest{TransactionData: hex.EncodeToString(op.Bytes())})
+	assert.NoError(t, c.bumpFeeForReplacement(ctx, op, key, c.txLimits.MaxFee))
+	assert.Equal(t, int64(1000), op.Limits().Fee)
+
+	// A fee that has already been raised enough is left alone
+	c.recordSentFee(key, op)
+	op.Contents[0].WithLimits(tezos.Limits{Fee: 2000})
+	assert.NoError(t, c.bumpFeeForReplacement(ctx, op, key, c.txLimits.MaxFee))
+	assert.Equal(t, int64(2000), op.Limits().Fee)
+}
+
 func TestTransactionSendDecodeStrError(t *testing.T) {
 	ctx, c, _, done := newTestConnector(t)
 	defer done()
@@ -121,7 +464,7 @@ func TestTransactionSendBroadcastError(t *testing.T) {
 		w.Write([]byte("{\"signature\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
 	}))
 	defer svr.Close()
-	c.signatoryURL = svr.URL
+	c.signer = newTestSignatorySigner(svr.URL)
 
 	mRPC.On("GetBlockHash", ctx, mock.Anything).
 		Return(tezos.NewBlockHash([]byte("BMBeYrMJpLWrqCs7UTcFaUQCeWBqsjCLejX5D8zE8m9syHqHnZg")), nil)
@@ -166,7 +509,7 @@ func TestTransactionSendSignTxError(t *testing.T) {
 		w.Write([]byte("internal error"))
 	}))
 	defer svr.Close()
-	c.signatoryURL = svr.URL
+	c.signer = newTestSignatorySigner(svr.URL)
 
 	req := &ffcapi.TransactionSendRequest{
 		TransactionHeaders: ffcapi.TransactionHeaders{
@@ -179,87 +522,139 @@ func TestTransactionSendSignTxError(t *testing.T) {
 	assert.Error(t, err)
 }
 
-func Test_signTxRemotelyNilOperationError(t *testing.T) {
+func TestTransactionSendAmountMismatch(t *testing.T) {
 	ctx, c, _, done := newTestConnector(t)
 	defer done()
 
-	err := c.signTxRemotely(ctx, nil)
-	assert.Error(t, err)
-}
-
-func Test_signTxRemotelyNilContextError(t *testing.T) {
-	_, c, _, done := newTestConnector(t)
-	defer done()
-
-	op := codec.NewOp()
-	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
-
-	err := c.signTxRemotely(nil, op)
-	assert.Error(t, err)
+	req := &ffcapi.TransactionSendRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From:  "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+			To:    "KT1D254HTPKq5GZNVcF73XBinG9BLybHqu8s",
+			Value: fftypes.NewFFBigInt(5),
+		},
+		TransactionData: "424d426559724d4a704c577271437337555463466155514365574271736a434c6c00889816a17ae688c971be1ad34bfe1990f8fa5e0f000b0000000130a980e6e41028da2cacfca4ddefea252d18bed900ffff05706175736500000002030a",
+	}
+	_, reason, err := c.TransactionSend(ctx, req)
+	assert.Regexp(t, "FF23060.*5.*0", err)
+	assert.Equal(t, ffcapi.ErrorReasonInvalidInputs, reason)
 }
 
-func Test_signTxRemotelyHttpError(t *testing.T) {
-	ctx, c, _, done := newTestConnector(t)
-	defer done()
-
-	op := codec.NewOp()
-	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
-
-	err := c.signTxRemotely(ctx, op)
-	assert.Error(t, err)
+func testOpData(withReveal bool) string {
+	source := tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
+	op := codec.NewOp().
+		WithBranch(tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL")).
+		WithSource(source)
+	if withReveal {
+		op.WithContents(&codec.Reveal{
+			Manager:   codec.Manager{Source: source, Fee: 400, GasLimit: 270},
+			PublicKey: tezos.MustParseKey("edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh"),
+		})
+	}
+	op.WithContents(&codec.Transaction{
+		Manager:     codec.Manager{Source: source, Fee: 500, GasLimit: 1100},
+		Destination: tezos.MustParseAddress("tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"),
+	})
+	return hex.EncodeToString(op.Bytes())
 }
 
-func Test_signTxRemotelyHttpWrongStatusError(t *testing.T) {
-	ctx, c, _, done := newTestConnector(t)
+func TestTransactionSendRemovesRevealOfRevealedAccount(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
 	defer done()
 
-	op := codec.NewOp()
-	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
-
-	// Set up http mocks
 	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(500)
-		w.Write([]byte("internal error"))
+		w.Write([]byte("{\"signature\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
 	}))
 	defer svr.Close()
-	c.signatoryURL = svr.URL
+	c.signer = newTestSignatorySigner(svr.URL)
 
-	err := c.signTxRemotely(ctx, op)
-	assert.Error(t, err)
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL"), nil)
+	// The key was revealed by another operation after this one was prepared
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{
+			Counter: 11,
+			Manager: "edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh",
+		}, nil)
+	mRPC.On("Broadcast", ctx, mock.Anything).
+		Run(func(args mock.Arguments) {
+			op := args[1].(*codec.Op)
+			assert.Len(t, op.Contents, 1)
+			assert.Equal(t, tezos.OpTypeTransaction, op.Contents[0].Kind())
+			assert.Equal(t, int64(12), op.Contents[0].GetCounter())
+		}).
+		Return(tezos.OpHash([]byte("oovD5cUigLGLT6kGDqsLMyF2sc3MLyfYhJWRymCPxUKEx3vtQ5v")), nil)
+
+	_, _, err := c.TransactionSend(ctx, &ffcapi.TransactionSendRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+		},
+		TransactionData: testOpData(true),
+	})
+	assert.NoError(t, err)
 }
 
-func Test_signTxRemotelyUnmarshalRespError(t *testing.T) {
-	ctx, c, _, done := newTestConnector(t)
+func TestTransactionSendAddsAndPricesReveal(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
 	defer done()
 
-	op := codec.NewOp()
-	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
-
-	// Set up http mocks
 	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write(nil)
+		if r.Method == http.MethodGet {
+			w.Write([]byte("{\"public_key\":\"edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh\"}"))
+			return
+		}
+		w.Write([]byte("{\"signature\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
 	}))
 	defer svr.Close()
-	c.signatoryURL = svr.URL
+	c.signer = newTestSignatorySigner(svr.URL)
 
-	err := c.signTxRemotely(ctx, op)
-	assert.Error(t, err)
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL"), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{Counter: 10}, nil)
+	mockSimulateWithReveal(mRPC, 170000, 1000000)
+	mRPC.On("Broadcast", ctx, mock.Anything).
+		Run(func(args mock.Arguments) {
+			op := args[1].(*codec.Op)
+			assert.Len(t, op.Contents, 2)
+			assert.Equal(t, tezos.OpTypeReveal, op.Contents[0].Kind())
+			assert.Equal(t, int64(270), op.Contents[0].Limits().GasLimit)
+			assert.Positive(t, op.Contents[0].Limits().Fee)
+			assert.Equal(t, int64(11), op.Contents[0].GetCounter())
+			assert.Equal(t, int64(12), op.Contents[1].GetCounter())
+		}).
+		Return(tezos.OpHash([]byte("oovD5cUigLGLT6kGDqsLMyF2sc3MLyfYhJWRymCPxUKEx3vtQ5v")), nil)
+
+	_, _, err := c.TransactionSend(ctx, &ffcapi.TransactionSendRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+		},
+		TransactionData: testOpData(false),
+	})
+	assert.NoError(t, err)
 }
 
-func Test_signTxRemotelyUnmarshalSignatureError(t *testing.T) {
-	ctx, c, _, done := newTestConnector(t)
+func TestTransactionSendAddedRevealSimulationError(t *testing.T) {
+	ctx, c, mRPC, done := newTestConnector(t)
 	defer done()
 
-	op := codec.NewOp()
-	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
-
-	// Set up http mocks
 	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("{\"wrong\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
+		w.Write([]byte("{\"public_key\":\"edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh\"}"))
 	}))
 	defer svr.Close()
-	c.signatoryURL = svr.URL
+	c.signer = newTestSignatorySigner(svr.URL)
 
-	err := c.signTxRemotely(ctx, op)
-	assert.Error(t, err)
+	mRPC.On("GetBlockHash", ctx, mock.Anything).
+		Return(tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL"), nil)
+	mRPC.On("GetContractExt", ctx, mock.Anything, mock.Anything).
+		Return(&rpc.ContractInfo{Counter: 10}, nil)
+	mRPC.On("Simulate", ctx, mock.Anything, mock.Anything).
+		Return(nil, errors.New("pop"))
+
+	_, _, err := c.TransactionSend(ctx, &ffcapi.TransactionSendRequest{
+		TransactionHeaders: ffcapi.TransactionHeaders{
+			From: "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN",
+		},
+		TransactionData: testOpData(false),
+	})
+	assert.Regexp(t, "pop", err)
 }
@@ -0,0 +1,98 @@
+package tezos
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-tezosconnect/internal/msgs"
+	"github.com/trilitech/tzgo/codec"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+const (
+	signerTypeSignatory = "signatory"
+	signerTypeKeystore  = "keystore"
+	signerTypeJSONRPC   = "jsonrpc"
+)
+
+// signer has access to the keys of the accounts that operations are submitted for, either holding them itself
+// or through a remote service
+type signer interface {
+	// PublicKey returns the public key of an account, which is needed to reveal it
+	PublicKey(ctx context.Context, address tezos.Address) (*tezos.Key, error)
+	// Sign returns the signature of the watermarked bytes of an operation, with the key of an account
+	Sign(ctx context.Context, address tezos.Address, watermarkedBytes []byte) (tezos.Signature, error)
+	// Addresses returns the addresses of the accounts that the signer has keys for
+	Addresses(ctx context.Context) ([]tezos.Address, error)
+}
+
+func newSigner(ctx context.Context, conf config.Section) (signer, error) {
+	switch signerType := conf.GetString(SignerType); signerType {
+	case signerTypeSignatory:
+		return newSignatorySigner(ctx, conf.SubSection(BlockchainSignatory))
+	case signerTypeKeystore:
+		return newKeystoreSigner(ctx, conf.GetString(SignerKeystorePath), conf.GetString(SignerKeystorePasswordFile))
+	case signerTypeJSONRPC:
+		return newJSONRPCSigner(ctx, conf.SubSection(SignerJSONRPC))
+	default:
+		return nil, i18n.NewError(ctx, msgs.MsgUnknownSignerType, signerType)
+	}
+}
+
+// checkSignerAddress checks that the signer has the key of the source of an operation, so that an operation that
+// could never be signed is rejected when it is prepared. The addresses of the signer are cached, and listed again
+// when an address is not found, to pick up keys added to the signer. A signer that cannot list its addresses, or
+// fails to, is not checked.
+func (c *tezosConnector) checkSignerAddress(ctx context.Context, address tezos.Address) error {
+	c.signerMux.Lock()
+	defer c.signerMux.Unlock()
+	if c.signerAddresses[address.String()] {
+		return nil
+	}
+	addresses, err := c.signer.Addresses(ctx)
+	if err != nil {
+		var ffErr i18n.FFError
+		if !errors.As(err, &ffErr) || ffErr.MessageKey() != msgs.MsgSignerCannotListAddresses {
+			log.L(ctx).Warnf("Failed to list the addresses of the signer, so the key of %s is not checked: %s", address, err)
+		}
+		return nil
+	}
+	c.signerAddresses = make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		c.signerAddresses[a.String()] = true
+	}
+	if !c.signerAddresses[address.String()] {
+		return i18n.NewError(ctx, msgs.MsgSignerMissingAddress, address)
+	}
+	return nil
+}
+
+// publicKey returns the public key of an account from the signer, caching it so that the signer is not called for
+// every operation of an account that has not been revealed yet
+func (c *tezosConnector) publicKey(ctx context.Context, address tezos.Address) (*tezos.Key, error) {
+	if cached, ok := c.publicKeys.Get(address.String()); ok {
+		return cached.(*tezos.Key), nil
+	}
+	key, err := c.signer.PublicKey(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	c.publicKeys.Add(address.String(), key)
+	return key, nil
+}
+
+// signOp signs an operation with the key of its source
+func (c *tezosConnector) signOp(ctx context.Context, op *codec.Op) error {
+	if op == nil {
+		return errors.New("operation is empty")
+	}
+	sig, err := c.signer.Sign(ctx, op.Source, op.WatermarkedBytes())
+	if err != nil {
+		return err
+	}
+	op.WithSignature(sig)
+	return nil
+}
@@ -0,0 +1,109 @@
+package tezos
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/ffresty"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-tezosconnect/internal/msgs"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+// The methods of the JSON-RPC signer protocol, which follows that of the FireFly signer for EVM chains
+const (
+	jsonrpcMethodAccounts     = "tezos_accounts"     // [] -> ["tz1..."]
+	jsonrpcMethodGetPublicKey = "tezos_getPublicKey" // ["tz1..."] -> "edpk..."
+	jsonrpcMethodSign         = "tezos_sign"         // ["tz1...", "<hex watermarked bytes>"] -> "edsig..."
+)
+
+// jsonrpcSigner uses a remote key service that speaks the JSON-RPC signer protocol
+type jsonrpcSigner struct {
+	client *resty.Client
+	nextID atomic.Int64
+}
+
+type jsonrpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int64         `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int64  `json:"code"`
+	Message string `json:"message"`
+}
+
+func newJSONRPCSigner(ctx context.Context, conf config.Section) (*jsonrpcSigner, error) {
+	client, err := ffresty.New(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonrpcSigner{client: client}, nil
+}
+
+func (s *jsonrpcSigner) call(ctx context.Context, method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	req := &jsonrpcRequest{
+		JSONRPC: "2.0",
+		ID:      s.nextID.Add(1),
+		Method:  method,
+		Params:  params,
+	}
+	var res jsonrpcResponse
+	resp, err := s.client.R().
+		SetContext(ctx).
+		SetBody(req).
+		SetResult(&res).
+		SetError(&res).
+		Post("")
+	if err != nil {
+		return i18n.NewError(ctx, msgs.MsgSignerRequestFailed, method, err)
+	}
+	if res.Error != nil {
+		return i18n.NewError(ctx, msgs.MsgSignerRPCError, method, res.Error.Code, res.Error.Message)
+	}
+	if resp.IsError() {
+		return i18n.NewError(ctx, msgs.MsgSignerRequestFailed, method, resp.Status())
+	}
+	if err := json.Unmarshal(res.Result, result); err != nil {
+		return i18n.NewError(ctx, msgs.MsgSignerInvalidResult, method, err)
+	}
+	return nil
+}
+
+func (s *jsonrpcSigner) PublicKey(ctx context.Context, address tezos.Address) (*tezos.Key, error) {
+	var key tezos.Key
+	if err := s.call(ctx, jsonrpcMethodGetPublicKey, &key, address.String()); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *jsonrpcSigner) Sign(ctx context.Context, address tezos.Address, watermarkedBytes []byte) (tezos.Signature, error) {
+	var sig tezos.Signature
+	err := s.call(ctx, jsonrpcMethodSign, &sig, address.String(), hex.EncodeToString(watermarkedBytes))
+	return sig, err
+}
+
+func (s *jsonrpcSigner) Addresses(ctx context.Context) ([]tezos.Address, error) {
+	var addresses []tezos.Address
+	if err := s.call(ctx, jsonrpcMethodAccounts, &addresses); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
@@ -0,0 +1,159 @@
+package tezos
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/ffresty"
+	"github.com/stretchr/testify/assert"
+	"github.com/trilitech/tzgo/codec"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+func newTestJSONRPCSigner(t *testing.T, handler func(req *jsonrpcRequest) *jsonrpcResponse) (*jsonrpcSigner, func()) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonrpcRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		assert.NoError(t, err)
+		assert.Equal(t, "2.0", req.JSONRPC)
+		res := handler(&req)
+		res.JSONRPC = "2.0"
+		res.ID = req.ID
+		w.Header().Set("Content-Type", "application/json")
+		if res.Error != nil {
+			w.WriteHeader(500)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+
+	config.RootConfigReset()
+	conf := config.RootSection("unittest")
+	InitConfig(conf)
+	signerConf := conf.SubSection(SignerJSONRPC)
+	signerConf.Set(ffresty.HTTPConfigURL, svr.URL)
+	conf.Set(SignerType, signerTypeJSONRPC)
+
+	s, err := newSigner(context.Background(), conf)
+	assert.NoError(t, err)
+	assert.IsType(t, &jsonrpcSigner{}, s)
+	return s.(*jsonrpcSigner), svr.Close
+}
+
+func jsonrpcResult(t *testing.T, result interface{}) *jsonrpcResponse {
+	b, err := json.Marshal(result)
+	assert.NoError(t, err)
+	return &jsonrpcResponse{Result: b}
+}
+
+func TestJSONRPCSigner(t *testing.T) {
+	ctx := context.Background()
+	key, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	assert.NoError(t, err)
+
+	s, done := newTestJSONRPCSigner(t, func(req *jsonrpcRequest) *jsonrpcResponse {
+		switch req.Method {
+		case jsonrpcMethodAccounts:
+			assert.Empty(t, req.Params)
+			return jsonrpcResult(t, []string{key.Address().String()})
+		case jsonrpcMethodGetPublicKey:
+			assert.Equal(t, []interface{}{key.Address().String()}, req.Params)
+			return jsonrpcResult(t, key.Public().String())
+		case jsonrpcMethodSign:
+			assert.Equal(t, key.Address().String(), req.Params[0])
+			b, err := hex.DecodeString(req.Params[1].(string))
+			assert.NoError(t, err)
+			digest := tezos.Digest(b)
+			sig, err := key.Sign(digest[:])
+			assert.NoError(t, err)
+			return jsonrpcResult(t, sig.String())
+		}
+		return &jsonrpcResponse{Error: &jsonrpcError{Code: -32601, Message: "method not found"}}
+	})
+	defer done()
+
+	addresses, err := s.Addresses(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []tezos.Address{key.Address()}, addresses)
+
+	pub, err := s.PublicKey(ctx, key.Address())
+	assert.NoError(t, err)
+	assert.True(t, key.Public().IsEqual(*pub))
+
+	_, c, _, connectorDone := newTestConnector(t)
+	defer connectorDone()
+	c.signer = s
+	op := codec.NewOp().
+		WithBranch(tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL")).
+		WithContents(&codec.Transaction{
+			Amount:      1,
+			Destination: tezos.MustParseAddress("tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"),
+		}).
+		WithSource(key.Address())
+	err = c.signOp(ctx, op)
+	assert.NoError(t, err)
+	assert.NoError(t, key.Public().Verify(op.Digest(), op.Signature))
+}
+
+func TestJSONRPCSignerRPCError(t *testing.T) {
+	s, done := newTestJSONRPCSigner(t, func(req *jsonrpcRequest) *jsonrpcResponse {
+		return &jsonrpcResponse{Error: &jsonrpcError{Code: -32000, Message: "key not found"}}
+	})
+	defer done()
+
+	_, err := s.Sign(context.Background(), tezos.MustParseAddress("tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"), []byte{})
+	assert.Regexp(t, "FF23067.*tezos_sign.*-32,000 key not found", err)
+}
+
+func TestJSONRPCSignerInvalidResult(t *testing.T) {
+	s, done := newTestJSONRPCSigner(t, func(req *jsonrpcRequest) *jsonrpcResponse {
+		return jsonrpcResult(t, "wrong")
+	})
+	defer done()
+
+	_, err := s.PublicKey(context.Background(), tezos.MustParseAddress("tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"))
+	assert.Regexp(t, "FF23068.*tezos_getPublicKey", err)
+}
+
+func TestJSONRPCSignerRequestFailed(t *testing.T) {
+	s, done := newTestJSONRPCSigner(t, func(req *jsonrpcRequest) *jsonrpcResponse {
+		return &jsonrpcResponse{}
+	})
+	done()
+
+	_, err := s.Addresses(context.Background())
+	assert.Regexp(t, "FF23066.*tezos_accounts", err)
+}
+
+func TestJSONRPCSignerHTTPError(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	}))
+	defer svr.Close()
+	config.RootConfigReset()
+	conf := config.RootSection("unittest")
+	InitConfig(conf)
+	conf.SubSection(SignerJSONRPC).Set(ffresty.HTTPConfigURL, svr.URL)
+	s, err := newJSONRPCSigner(context.Background(), conf.SubSection(SignerJSONRPC))
+	assert.NoError(t, err)
+
+	_, err = s.Addresses(context.Background())
+	assert.Regexp(t, "FF23066.*tezos_accounts.*404", err)
+}
+
+func TestJSONRPCSignerConfigError(t *testing.T) {
+	config.RootConfigReset()
+	conf := config.RootSection("unittest")
+	InitConfig(conf)
+	conf.Set(SignerType, signerTypeJSONRPC)
+	conf.SubSection(SignerJSONRPC).Set(ffresty.HTTPConfigURL, "http://localhost")
+	conf.SubSection(SignerJSONRPC).SubSection("tls").Set("enabled", true)
+	conf.SubSection(SignerJSONRPC).SubSection("tls").Set("caFile", "!!!badness")
+
+	_, err := newSigner(context.Background(), conf)
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package tezos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-tezosconnect/internal/msgs"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+// keystoreSecretKeysFile is the file in which the Tezos client stores secret keys, in its base directory
+const keystoreSecretKeysFile = "secret_keys"
+
+// keystoreSigner signs in process, with tz1, tz2 and tz3 secret keys loaded from a keystore directory in the
+// format of the Tezos client. It is intended for development and test environments, such as a local sandbox.
+type keystoreSigner struct {
+	keys map[string]tezos.PrivateKey
+}
+
+// keystoreEntry is a named secret key, which is either "encrypted:<edesk|spesk|p2esk...>" with the password
+// of the keystore, or "unencrypted:<edsk|spsk|p2sk...>"
+type keystoreEntry struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func newKeystoreSigner(ctx context.Context, path, passwordFile string) (*keystoreSigner, error) {
+	var password []byte
+	if passwordFile != "" {
+		b, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, i18n.NewError(ctx, msgs.MsgKeystoreLoadFailed, path, err)
+		}
+		password = bytes.TrimSpace(b)
+	}
+
+	b, err := os.ReadFile(filepath.Join(path, keystoreSecretKeysFile))
+	if err != nil {
+		return nil, i18n.NewError(ctx, msgs.MsgKeystoreLoadFailed, path, err)
+	}
+	var entries []keystoreEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, i18n.NewError(ctx, msgs.MsgKeystoreLoadFailed, path, err)
+	}
+
+	s := &keystoreSigner{
+		keys: make(map[string]tezos.PrivateKey, len(entries)),
+	}
+	for _, entry := range entries {
+		var key tezos.PrivateKey
+		scheme, value, _ := strings.Cut(entry.Value, ":")
+		switch scheme {
+		case "encrypted":
+			if password == nil {
+				return nil, i18n.NewError(ctx, msgs.MsgKeystoreKeyFailed, entry.Name, path, "no password for encrypted key")
+			}
+			key, err = tezos.ParseEncryptedPrivateKey(value, func() ([]byte, error) {
+				return bytes.Clone(password), nil
+			})
+		case "unencrypted":
+			log.L(ctx).Warnf("Key '%s' in keystore %s is not encrypted", entry.Name, path)
+			key, err = tezos.ParsePrivateKey(value)
+		default:
+			return nil, i18n.NewError(ctx, msgs.MsgKeystoreKeyFailed, entry.Name, path, "unsupported key scheme '"+scheme+"'")
+		}
+		if err != nil {
+			return nil, i18n.NewError(ctx, msgs.MsgKeystoreKeyFailed, entry.Name, path, err)
+		}
+		s.keys[key.Address().String()] = key
+	}
+	log.L(ctx).Infof("Loaded %d keys from keystore %s", len(s.keys), path)
+	return s, nil
+}
+
+func (s *keystoreSigner) getKey(ctx context.Context, address tezos.Address) (tezos.PrivateKey, error) {
+	key, ok := s.keys[address.String()]
+	if !ok {
+		return key, i18n.NewError(ctx, msgs.MsgKeyNotFound, address)
+	}
+	return key, nil
+}
+
+func (s *keystoreSigner) PublicKey(ctx context.Context, address tezos.Address) (*tezos.Key, error) {
+	key, err := s.getKey(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	pub := key.Public()
+	return &pub, nil
+}
+
+func (s *keystoreSigner) Sign(ctx context.Context, address tezos.Address, watermarkedBytes []byte) (tezos.Signature, error) {
+	key, err := s.getKey(ctx, address)
+	if err != nil {
+		return tezos.Signature{}, err
+	}
+	digest := tezos.Digest(watermarkedBytes)
+	return key.Sign(digest[:])
+}
+
+func (s *keystoreSigner) Addresses(ctx context.Context) ([]tezos.Address, error) {
+	addresses := make([]tezos.Address, 0, len(s.keys))
+	for _, key := range s.keys {
+		addresses = append(addresses, key.Address())
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].String() < addresses[j].String() })
+	return addresses, nil
+}
@@ -0,0 +1,154 @@
+package tezos
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/trilitech/tzgo/codec"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+func newTestKeystore(t *testing.T, password string, entries ...keystoreEntry) (dir, passwordFile string) {
+	dir = t.TempDir()
+	b, _ := json.Marshal(entries)
+	err := os.WriteFile(filepath.Join(dir, keystoreSecretKeysFile), b, 0600)
+	assert.NoError(t, err)
+	passwordFile = filepath.Join(dir, "password")
+	err = os.WriteFile(passwordFile, []byte(password+"\n"), 0600)
+	assert.NoError(t, err)
+	return dir, passwordFile
+}
+
+func encryptedEntry(t *testing.T, name string, key tezos.PrivateKey, password string) keystoreEntry {
+	enc, err := key.Encrypt(func() ([]byte, error) { return []byte(password), nil })
+	assert.NoError(t, err)
+	return keystoreEntry{Name: name, Value: "encrypted:" + enc}
+}
+
+func TestKeystoreSigner(t *testing.T) {
+	ctx := context.Background()
+	var keys []tezos.PrivateKey
+	for _, typ := range []tezos.KeyType{tezos.KeyTypeEd25519, tezos.KeyTypeSecp256k1, tezos.KeyTypeP256} {
+		key, err := tezos.GenerateKey(typ)
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	dir, passwordFile := newTestKeystore(t, "secret",
+		encryptedEntry(t, "tz1", keys[0], "secret"),
+		encryptedEntry(t, "tz2", keys[1], "secret"),
+		keystoreEntry{Name: "tz3", Value: "unencrypted:" + keys[2].String()},
+	)
+
+	s, err := newKeystoreSigner(ctx, dir, passwordFile)
+	assert.NoError(t, err)
+	assert.Len(t, s.keys, 3)
+
+	addresses, err := s.Addresses(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, addresses, 3)
+	for _, key := range keys {
+		assert.Contains(t, addresses, key.Address())
+	}
+
+	for _, key := range keys {
+		address := key.Address()
+		pub, err := s.PublicKey(ctx, address)
+		assert.NoError(t, err)
+		assert.True(t, key.Public().IsEqual(*pub))
+
+		message := []byte("watermarked bytes")
+		sig, err := s.Sign(ctx, address, message)
+		assert.NoError(t, err)
+		digest := tezos.Digest(message)
+		assert.NoError(t, pub.Verify(digest[:], sig))
+	}
+
+	_, err = s.PublicKey(ctx, tezos.MustParseAddress("tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"))
+	assert.Regexp(t, "FF23065.*tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb", err)
+	_, err = s.Sign(ctx, tezos.MustParseAddress("tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"), []byte{})
+	assert.Regexp(t, "FF23065", err)
+}
+
+func TestKeystoreSignerSignOp(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	key, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	assert.NoError(t, err)
+	dir, passwordFile := newTestKeystore(t, "secret", encryptedEntry(t, "alice", key, "secret"))
+	c.signer, err = newKeystoreSigner(ctx, dir, passwordFile)
+	assert.NoError(t, err)
+
+	op := codec.NewOp().
+		WithBranch(tezos.MustParseBlockHash("BLc1BjmZ7WevMoMoj8jxh4k2wLoRqoMUxjrQuDmKzAsApfRRjFL")).
+		WithContents(&codec.Transaction{
+			Amount:      1,
+			Destination: tezos.MustParseAddress("tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"),
+		}).
+		WithSource(key.Address())
+	err = c.signOp(ctx, op)
+	assert.NoError(t, err)
+	assert.NoError(t, key.Public().Verify(op.Digest(), op.Signature))
+}
+
+func TestKeystoreSignerConfig(t *testing.T) {
+	key, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	assert.NoError(t, err)
+	dir, passwordFile := newTestKeystore(t, "secret", encryptedEntry(t, "alice", key, "secret"))
+
+	config.RootConfigReset()
+	conf := config.RootSection("unittest")
+	InitConfig(conf)
+	conf.Set(SignerType, signerTypeKeystore)
+	conf.Set(SignerKeystorePath, dir)
+	conf.Set(SignerKeystorePasswordFile, passwordFile)
+
+	s, err := newSigner(context.Background(), conf)
+	assert.NoError(t, err)
+	assert.IsType(t, &keystoreSigner{}, s)
+}
+
+func TestKeystoreSignerLoadErrors(t *testing.T) {
+	ctx := context.Background()
+	key, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	assert.NoError(t, err)
+
+	// Encrypted key without a password
+	dir, _ := newTestKeystore(t, "secret", encryptedEntry(t, "alice", key, "secret"))
+	_, err = newKeystoreSigner(ctx, dir, "")
+	assert.Regexp(t, "FF23064.*alice.*no password", err)
+
+	// Wrong password
+	dir, passwordFile := newTestKeystore(t, "wrong", encryptedEntry(t, "alice", key, "secret"))
+	_, err = newKeystoreSigner(ctx, dir, passwordFile)
+	assert.Regexp(t, "FF23064.*alice", err)
+
+	// Key that is not held locally
+	dir, passwordFile = newTestKeystore(t, "secret", keystoreEntry{Name: "ledger", Value: "ledger://path"})
+	_, err = newKeystoreSigner(ctx, dir, passwordFile)
+	assert.Regexp(t, "FF23064.*ledger.*unsupported key scheme 'ledger'", err)
+
+	// Invalid key
+	dir, passwordFile = newTestKeystore(t, "secret", keystoreEntry{Name: "bad", Value: "unencrypted:edskwrong"})
+	_, err = newKeystoreSigner(ctx, dir, passwordFile)
+	assert.Regexp(t, "FF23064.*bad", err)
+
+	// Missing password file
+	_, err = newKeystoreSigner(ctx, dir, filepath.Join(dir, "missing"))
+	assert.Regexp(t, "FF23063", err)
+
+	// Missing keystore
+	_, err = newKeystoreSigner(ctx, filepath.Join(dir, "missing"), "")
+	assert.Regexp(t, "FF23063", err)
+
+	// Invalid keystore
+	err = os.WriteFile(filepath.Join(dir, keystoreSecretKeysFile), []byte("{wrong"), 0600)
+	assert.NoError(t, err)
+	_, err = newKeystoreSigner(ctx, dir, "")
+	assert.Regexp(t, "FF23063", err)
+}
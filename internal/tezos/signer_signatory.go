@@ -0,0 +1,125 @@
+package tezos
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/ffresty"
+	"github.com/hyperledger/firefly-common/pkg/i18n"
+	"github.com/hyperledger/firefly-common/pkg/log"
+	"github.com/hyperledger/firefly-tezosconnect/internal/msgs"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+// signatoryAuthWatermark prefixes the bytes signed by the authentication key of a sign request, as Signatory
+// expects them: the watermark, then the binary address of the signing key, then the bytes being signed
+const signatoryAuthWatermark = 0x04
+
+// signatorySigner uses a Signatory service (https://signatory.io) that holds the keys
+type signatorySigner struct {
+	client  *resty.Client // nil if the URL of the signatory is not set
+	authKey *tezos.PrivateKey
+}
+
+func newSignatorySigner(ctx context.Context, conf config.Section) (*signatorySigner, error) {
+	s := &signatorySigner{}
+	// the URL was previously set as the value of blockchain.signatory itself, which now leaves it empty.
+	// The connector still starts without it, for deployments that only query the chain or listen for events,
+	// and operations fail to be signed until it is set.
+	if conf.GetString(ffresty.HTTPConfigURL) == "" {
+		log.L(ctx).Warnf("No URL is set at blockchain.signatory.url, so operations cannot be signed")
+	} else {
+		client, err := ffresty.New(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	if authKey := conf.GetString(SignatoryAuthKey); authKey != "" {
+		key, err := tezos.ParsePrivateKey(authKey)
+		if err != nil {
+			return nil, i18n.NewError(ctx, msgs.MsgInvalidSignatoryAuthKey, err)
+		}
+		s.authKey = &key
+	}
+	return s, nil
+}
+
+func (s *signatorySigner) PublicKey(ctx context.Context, address tezos.Address) (*tezos.Key, error) {
+	if s.client == nil {
+		return nil, i18n.NewError(ctx, msgs.MsgMissingSignatoryURL)
+	}
+	res, err := s.client.R().
+		SetContext(ctx).
+		Get("/keys/" + address.String())
+	if err != nil || res.IsError() {
+		return nil, ffresty.WrapRestErr(ctx, res, err, msgs.MsgSignatoryRequestFailed)
+	}
+
+	var pubKeyJSON struct {
+		PubKey string `json:"public_key"`
+	}
+	err = json.Unmarshal(res.Body(), &pubKeyJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := tezos.ParseKey(pubKeyJSON.PubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (s *signatorySigner) Sign(ctx context.Context, address tezos.Address, watermarkedBytes []byte) (tezos.Signature, error) {
+	var sig tezos.Signature
+	if s.client == nil {
+		return sig, i18n.NewError(ctx, msgs.MsgMissingSignatoryURL)
+	}
+	requestBody, _ := json.Marshal(hex.EncodeToString(watermarkedBytes))
+
+	req := s.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(requestBody)
+	if s.authKey != nil {
+		authSig, err := s.authenticate(address, watermarkedBytes)
+		if err != nil {
+			return sig, err
+		}
+		req.SetQueryParam("authentication", authSig.String())
+	}
+	res, err := req.Post("/keys/" + address.String())
+	if err != nil || res.IsError() {
+		return sig, ffresty.WrapRestErr(ctx, res, err, msgs.MsgSignatoryRequestFailed)
+	}
+
+	var signatureJSON struct {
+		Signature string
+	}
+	err = json.Unmarshal(res.Body(), &signatureJSON)
+	if err != nil {
+		return sig, err
+	}
+
+	err = sig.UnmarshalText([]byte(signatureJSON.Signature))
+	return sig, err
+}
+
+// authenticate signs a sign request with the authentication key, for a Signatory that only accepts requests
+// from authorized keys
+func (s *signatorySigner) authenticate(address tezos.Address, watermarkedBytes []byte) (tezos.Signature, error) {
+	data := append([]byte{signatoryAuthWatermark}, address.Encode()...)
+	data = append(data, watermarkedBytes...)
+	digest := tezos.Digest(data)
+	return s.authKey.Sign(digest[:])
+}
+
+// Addresses is not supported, as Signatory has no API to list the keys it holds
+func (s *signatorySigner) Addresses(ctx context.Context) ([]tezos.Address, error) {
+	return nil, i18n.NewError(ctx, msgs.MsgSignerCannotListAddresses, signerTypeSignatory)
+}
@@ -0,0 +1,201 @@
+package tezos
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/ffresty"
+	"github.com/stretchr/testify/assert"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+func newTestSignatorySigner(url string) *signatorySigner {
+	return &signatorySigner{client: resty.New().SetBaseURL(url)}
+}
+
+func TestSignatoryPublicKeySuccess(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	// Set up http mocks
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"public_key\":\"edpkvHVuLHkr5eDiTtQKyUPqgYVAk3Sy4m7qBD8r6abemHkZsMU5Kh\"}"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	resp, err := c.signer.PublicKey(ctx, tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+	assert.NotNil(t, resp)
+	assert.NoError(t, err)
+}
+
+func TestSignatoryPublicKeyCancelledContextError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	done()
+
+	_, err := c.signer.PublicKey(ctx, tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+	assert.Error(t, err)
+}
+
+func TestSignatoryPublicKeyHttpError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	_, err := c.signer.PublicKey(ctx, tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+	assert.Error(t, err)
+}
+
+func TestSignatoryPublicKeyHttpWrongStatusError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	// Set up http mocks
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		w.Write([]byte("internal error"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	_, err := c.signer.PublicKey(ctx, tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+	assert.Error(t, err)
+}
+
+func TestSignatoryPublicKeyUnmarshalRespError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	// Set up http mocks
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(nil)
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	_, err := c.signer.PublicKey(ctx, tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+	assert.Error(t, err)
+}
+
+func TestSignatoryPublicKeyInvalidRespKeyError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	// Set up http mocks
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"public_key\":\"invalid\"}"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	_, err := c.signer.PublicKey(ctx, tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+	assert.Error(t, err)
+}
+
+func TestSignatoryAddressesNotSupported(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	_, err := c.signer.Addresses(ctx)
+	assert.Regexp(t, "FF23069.*signatory", err)
+}
+
+func TestSignatorySignerConfig(t *testing.T) {
+	ctx := context.Background()
+	key, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	assert.NoError(t, err)
+	authKey, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	assert.NoError(t, err)
+	message := []byte("watermarked bytes")
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user", username)
+		assert.Equal(t, "pass", password)
+		assert.Equal(t, "/keys/"+key.Address().String(), r.URL.Path)
+
+		var body string
+		err := json.NewDecoder(r.Body).Decode(&body)
+		assert.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(message), body)
+
+		authSig, err := tezos.ParseSignature(r.URL.Query().Get("authentication"))
+		assert.NoError(t, err)
+		authData := append([]byte{0x04}, key.Address().Encode()...)
+		authDigest := tezos.Digest(append(authData, message...))
+		assert.NoError(t, authKey.Public().Verify(authDigest[:], authSig))
+
+		digest := tezos.Digest(message)
+		sig, err := key.Sign(digest[:])
+		assert.NoError(t, err)
+		w.Write([]byte(`{"signature":"` + sig.String() + `"}`))
+	}))
+	defer svr.Close()
+
+	config.RootConfigReset()
+	conf := config.RootSection("unittest")
+	InitConfig(conf)
+	signatoryConf := conf.SubSection(BlockchainSignatory)
+	signatoryConf.Set(ffresty.HTTPConfigURL, svr.URL)
+	signatoryConf.Set(ffresty.HTTPConfigAuthUsername, "user")
+	signatoryConf.Set(ffresty.HTTPConfigAuthPassword, "pass")
+	signatoryConf.Set(SignatoryAuthKey, authKey.String())
+
+	s, err := newSigner(ctx, conf)
+	assert.NoError(t, err)
+	assert.IsType(t, &signatorySigner{}, s)
+
+	sig, err := s.Sign(ctx, key.Address(), message)
+	assert.NoError(t, err)
+	digest := tezos.Digest(message)
+	assert.NoError(t, key.Public().Verify(digest[:], sig))
+}
+
+func TestSignatorySignerConfigErrors(t *testing.T) {
+	config.RootConfigReset()
+	conf := config.RootSection("unittest")
+	InitConfig(conf)
+	signatoryConf := conf.SubSection(BlockchainSignatory)
+	signatoryConf.Set(ffresty.HTTPConfigURL, "http://localhost:6732")
+	signatoryConf.Set(SignatoryAuthKey, "edskwrong")
+
+	_, err := newSigner(context.Background(), conf)
+	assert.Regexp(t, "FF23071", err)
+
+	signatoryConf.SubSection("tls").Set("enabled", true)
+	signatoryConf.SubSection("tls").Set("caFile", "!!!badness")
+	_, err = newSigner(context.Background(), conf)
+	assert.Error(t, err)
+}
+
+func TestSignatorySignerWithoutURL(t *testing.T) {
+	config.RootConfigReset()
+	conf := config.RootSection("unittest")
+	InitConfig(conf)
+
+	// The connector can start without a signatory, but cannot sign operations
+	s, err := newSigner(context.Background(), conf)
+	assert.NoError(t, err)
+	address := tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN")
+	_, err = s.PublicKey(context.Background(), address)
+	assert.Regexp(t, "FF23072", err)
+	_, err = s.Sign(context.Background(), address, []byte("operation"))
+	assert.Regexp(t, "FF23072", err)
+}
+
+func TestSignatorySignHttpWrongStatusError(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(403)
+		w.Write([]byte("unauthorized"))
+	}))
+	defer svr.Close()
+
+	_, err := newTestSignatorySigner(svr.URL).Sign(context.Background(), tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"), []byte{})
+	assert.Regexp(t, "FF23070.*unauthorized", err)
+}
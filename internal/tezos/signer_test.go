@@ -0,0 +1,148 @@
+package tezos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/trilitech/tzgo/codec"
+	"github.com/trilitech/tzgo/tezos"
+)
+
+func TestNewSignerUnknownType(t *testing.T) {
+	config.RootConfigReset()
+	conf := config.RootSection("unittest")
+	InitConfig(conf)
+	conf.Set(SignerType, "wrong")
+
+	_, err := newSigner(context.Background(), conf)
+	assert.Regexp(t, "FF23062.*wrong", err)
+}
+
+func Test_signOpNilOperationError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	err := c.signOp(ctx, nil)
+	assert.Error(t, err)
+}
+
+func Test_signOpCancelledContextError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	done()
+
+	op := codec.NewOp()
+	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+
+	err := c.signOp(ctx, op)
+	assert.Error(t, err)
+}
+
+func Test_signOpHttpError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	op := codec.NewOp()
+	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+
+	err := c.signOp(ctx, op)
+	assert.Error(t, err)
+}
+
+func Test_signOpHttpWrongStatusError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	op := codec.NewOp()
+	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+
+	// Set up http mocks
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		w.Write([]byte("internal error"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	err := c.signOp(ctx, op)
+	assert.Error(t, err)
+}
+
+func Test_signOpUnmarshalRespError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	op := codec.NewOp()
+	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+
+	// Set up http mocks
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(nil)
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	err := c.signOp(ctx, op)
+	assert.Error(t, err)
+}
+
+func Test_signOpUnmarshalSignatureError(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	op := codec.NewOp()
+	op.WithSource(tezos.MustParseAddress("tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN"))
+
+	// Set up http mocks
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"wrong\":\"sigWetzF5zVM2qdYt8QToj7e5cNBm9neiPRc3rpePBDrr8N1brFbErv2YfXMSoSgemJ8AwZcLfmkBDg78bmUEzF1sf1YotnS\"}"))
+	}))
+	defer svr.Close()
+	c.signer = newTestSignatorySigner(svr.URL)
+
+	err := c.signOp(ctx, op)
+	assert.Error(t, err)
+}
+
+func TestCheckSignerAddress(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	key, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	assert.NoError(t, err)
+	other, err := tezos.GenerateKey(tezos.KeyTypeEd25519)
+	assert.NoError(t, err)
+	s := &keystoreSigner{keys: map[string]tezos.PrivateKey{key.Address().String(): key}}
+	c.signer = s
+
+	assert.NoError(t, c.checkSignerAddress(ctx, key.Address()))
+	err = c.checkSignerAddress(ctx, other.Address())
+	assert.Regexp(t, "FF23074.*"+other.Address().String(), err)
+
+	// A key added to the signer is found when the addresses are listed again
+	s.keys[other.Address().String()] = other
+	assert.NoError(t, c.checkSignerAddress(ctx, other.Address()))
+
+	// A signer that cannot list its addresses is not checked
+	c.signerAddresses = nil
+	c.signer = newTestSignatorySigner("http://localhost:6732")
+	assert.NoError(t, c.checkSignerAddress(ctx, other.Address()))
+	jsonrpcSigner, stop := newTestJSONRPCSigner(t, func(req *jsonrpcRequest) *jsonrpcResponse {
+		return &jsonrpcResponse{}
+	})
+	stop()
+	c.signer = jsonrpcSigner
+	assert.NoError(t, c.checkSignerAddress(ctx, other.Address()))
+}
+
+func TestCompleteOpSignerMissingAddress(t *testing.T) {
+	ctx, c, _, done := newTestConnector(t)
+	defer done()
+
+	c.signer = &keystoreSigner{keys: map[string]tezos.PrivateKey{}}
+	_, err := c.completeOp(ctx, codec.NewOp(), "tz1Y6GnVhC4EpcDDSmD3ibcC4WX6DJ4Q1QLN", nil)
+	assert.Regexp(t, "FF23074", err)
+}
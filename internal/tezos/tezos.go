@@ -24,15 +24,26 @@ type tezosConnector struct {
 	eventBlockTimestamps       bool
 	blockListener              *blockListener
 	eventFilterPollingInterval time.Duration
+	dataFormat                 dataFormat
+	gasEstimationFactor        float64
+	feeOverrides               feeOverrides
+	feeBumpPercent             float64
+	txLimits                   transactionLimits
+	receiptsMaxScanDepth       int64
 
-	client       rpc.RpcClient
-	networkName  string
-	signatoryURL string
+	client      rpc.RpcClient
+	networkName string
+	signer      signer
+
+	signerMux       sync.Mutex
+	signerAddresses map[string]bool // the addresses the signer had keys for when it was last listed
 
 	mux          sync.Mutex
 	eventStreams map[fftypes.UUID]*eventStream
 	blockCache   *lru.Cache
 	txCache      *lru.Cache
+	sentFees     *lru.Cache
+	publicKeys   *lru.Cache
 }
 
 func NewTezosConnector(ctx context.Context, conf config.Section) (cc ffcapi.API, err error) {
@@ -43,6 +54,15 @@ func NewTezosConnector(ctx context.Context, conf config.Section) (cc ffcapi.API,
 		checkpointBlockGap:         conf.GetInt64(EventsCheckpointBlockGap),
 		eventBlockTimestamps:       conf.GetBool(EventsBlockTimestamps),
 		eventFilterPollingInterval: conf.GetDuration(EventsFilterPollingInterval),
+		gasEstimationFactor:        conf.GetFloat64(ConfigGasEstimationFactor),
+		feeBumpPercent:             conf.GetFloat64(FeesBumpPercent),
+		receiptsMaxScanDepth:       conf.GetInt64(ReceiptsMaxScanDepth),
+		txLimits: transactionLimits{
+			MaxFee:          conf.GetInt64(FeesMaxFee),
+			MaxGasLimit:     conf.GetInt64(LimitsMaxGasLimit),
+			MaxStorageLimit: conf.GetInt64(LimitsMaxStorageLimit),
+			SafetyMargin:    conf.GetInt64(LimitsSafetyMargin),
+		},
 		retry: &retry.Retry{
 			InitialDelay: conf.GetDuration(RetryInitDelay),
 			MaximumDelay: conf.GetDuration(RetryMaxDelay),
@@ -53,6 +73,15 @@ func NewTezosConnector(ctx context.Context, conf config.Section) (cc ffcapi.API,
 		log.L(ctx).Warnf("Catchup threshold %d must be at least as large as the catchup page size %d (overridden to %d)", c.catchupThreshold, c.catchupPageSize, c.catchupPageSize)
 		c.catchupThreshold = c.catchupPageSize
 	}
+	c.dataFormat, err = parseDataFormat(ctx, conf.GetString(ConfigDataFormat))
+	if err != nil {
+		return nil, err
+	}
+	c.feeOverrides = feeOverrides{
+		minimalFee:        getOptionalInt64(conf, FeesMinimalFee),
+		nanotezPerByte:    getOptionalInt64(conf, FeesNanotezPerByte),
+		nanotezPerGasUnit: getOptionalInt64(conf, FeesNanotezPerGasUnit),
+	}
 	c.blockCache, err = lru.New(conf.GetInt(BlockCacheSize))
 	if err != nil {
 		return nil, i18n.WrapError(ctx, err, msgs.MsgCacheInitFail, "block")
@@ -63,6 +92,16 @@ func NewTezosConnector(ctx context.Context, conf config.Section) (cc ffcapi.API,
 		return nil, i18n.WrapError(ctx, err, msgs.MsgCacheInitFail, "transaction")
 	}
 
+	c.sentFees, err = lru.New(conf.GetInt(TxCacheSize))
+	if err != nil {
+		return nil, i18n.WrapError(ctx, err, msgs.MsgCacheInitFail, "sent fees")
+	}
+
+	c.publicKeys, err = lru.New(conf.GetInt(PublicKeyCacheSize))
+	if err != nil {
+		return nil, i18n.WrapError(ctx, err, msgs.MsgCacheInitFail, "public key")
+	}
+
 	rpcClientURL := conf.GetString(BlockchainRPC)
 	if rpcClientURL == "" {
 		return nil, i18n.WrapError(ctx, err, msgs.MsgMissingRPCUrl)
@@ -73,10 +112,21 @@ func NewTezosConnector(ctx context.Context, conf config.Section) (cc ffcapi.API,
 	}
 	c.networkName = conf.GetString(BlockchainNetwork)
 
-	// service for tx signing
-	c.signatoryURL = conf.GetString(BlockchainSignatory)
+	// keys for tx signing
+	c.signer, err = newSigner(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
 
 	c.blockListener = newBlockListener(ctx, c, conf)
 
 	return c, nil
 }
+
+func getOptionalInt64(conf config.Section, key string) *int64 {
+	if !conf.IsSet(key) {
+		return nil
+	}
+	v := conf.GetInt64(key)
+	return &v
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/hyperledger/firefly-common/pkg/config"
+	"github.com/hyperledger/firefly-common/pkg/ffresty"
 	"github.com/hyperledger/firefly-tezosconnect/mocks/tzrpcbackendmocks"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -16,6 +17,7 @@ func newTestConnector(t *testing.T) (context.Context, *tezosConnector, *tzrpcbac
 	conf := config.RootSection("unittest")
 	InitConfig(conf)
 	conf.AddKnownKey(BlockchainRPC, "https://ghostnet.example.com")
+	conf.SubSection(BlockchainSignatory).AddKnownKey(ffresty.HTTPConfigURL, "http://localhost:6732")
 	logrus.SetLevel(logrus.DebugLevel)
 	ctx, done := context.WithCancel(context.Background())
 	cc, err := NewTezosConnector(ctx, conf)
@@ -52,6 +54,12 @@ func TestConnectorInit(t *testing.T) {
 	assert.Regexp(t, "FF23052", err)
 	assert.Nil(t, cc)
 
+	conf.Set(BlockchainRPC, "https://rpc.ghostnet.teztnets.com")
+	conf.Set(ConfigDataFormat, "wrong")
+	cc, err = NewTezosConnector(context.Background(), conf)
+	assert.Regexp(t, "FF23032", err)
+	assert.Nil(t, cc)
+
 	conf.Set(ConfigDataFormat, "map")
 	conf.Set(BlockCacheSize, "-1")
 	cc, err = NewTezosConnector(context.Background(), conf)